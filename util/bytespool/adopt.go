@@ -0,0 +1,73 @@
+// Copyright 2017 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bytespool
+
+import (
+	"sync/atomic"
+)
+
+// Adopt hands the ownership of origin allocated from src over to bp without copying, so it can be freed to bp later.
+// For plain pools only the length matters, the work is moving the accounting: origin is no longer an outstanding
+// allocation of src nor counted in its budget, and it is recorded and counted by bp instead, like Alloc.
+// It returns false and leaves the ownership unchanged if bp can't pool the length of origin,
+// if origin is mapped by src, or if src tracks the allocations and origin is not outstanding in src.
+func (bp *BytesPool) Adopt(src *BytesPool, origin []byte) bool {
+	n := len(origin)
+	if !bp.canPool(n) || src.isMapped(origin) {
+		return false
+	}
+	if src.tracker != nil && !src.tracker.forget(origin) {
+		return false
+	}
+	// The large objects have no bucket counters, so only the bucket sizes move the live counts.
+	if src.isBucketSize(n) {
+		atomic.AddInt64(&src.counters[src.bucketIdx(n)].live, -1)
+	}
+	if src.opts.budget > 0 {
+		atomic.AddInt64(&src.liveBytes, -int64(n))
+		src.budgetWaiters.broadcast()
+	}
+	if src.safetyNet != nil {
+		src.disarm(origin)
+	}
+	if bp.isBucketSize(n) {
+		atomic.AddInt64(&bp.counters[bp.bucketIdx(n)].live, 1)
+	}
+	if bp.tracker != nil {
+		bp.tracker.record(origin, 1)
+	}
+	if bp.safetyNet != nil {
+		bp.arm(origin)
+	}
+	if bp.opts.budget > 0 {
+		bp.addLive(int64(n))
+	}
+	return true
+}
+
+// canPool reports whether the origin bytes of length n can be returned to the pool.
+// The buckets backed by mmap only accept the bytes mapped by the pool itself.
+func (bp *BytesPool) canPool(n int) bool {
+	if n > bp.maxSize {
+		return bp.isLargeSize(n)
+	}
+	if !bp.isBucketSize(n) {
+		return false
+	}
+	return bp.mmaps == nil || bp.bucketIdx(n) < bp.mmaps.from
+}
+
+func (bp *BytesPool) isMapped(origin []byte) bool {
+	return bp.mmaps != nil && len(origin) > 0 && bp.mmaps.owns(origin)
+}
@@ -0,0 +1,81 @@
+// Copyright 2017 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bytespool
+
+import (
+	"strings"
+
+	. "github.com/pingcap/check"
+)
+
+func (s *testBytesPoolSuite) TestAdopt(c *C) {
+	src, err := NewBytesPoolWithBudget(mega, WithLeakTracking())
+	c.Assert(err, IsNil)
+	dst, err := NewBytesPoolWithConfig(kilo, 4*kilo, WithBudget(mega), WithDoubleFreeCheck())
+	c.Assert(err, IsNil)
+
+	origin, _ := src.Alloc(3 * kilo)
+	c.Assert(dst.Adopt(src, origin), IsTrue)
+	c.Assert(src.LiveBytes(), Equals, int64(0))
+	c.Assert(src.OutstandingAllocations(), HasLen, 0)
+	c.Assert(dst.LiveBytes(), Equals, int64(4*kilo))
+	c.Assert(dst.Free(origin), Equals, 2)
+	c.Assert(dst.LiveBytes(), Equals, int64(0))
+
+	// Not outstanding in src.
+	c.Assert(dst.Adopt(src, origin), IsFalse)
+	// dst can't pool the length.
+	origin, _ = src.Alloc(8 * kilo)
+	c.Assert(dst.Adopt(src, origin), IsFalse)
+	c.Assert(src.OutstandingAllocations(), HasLen, 1)
+	c.Assert(src.Free(origin), Equals, 3)
+	c.Assert(dst.Adopt(src, nil), IsFalse)
+
+	// The stack recorded by dst starts from the caller of Adopt.
+	origin, _ = dst.Alloc(kilo)
+	c.Assert(src.Adopt(dst, origin), IsTrue)
+	allocs := src.OutstandingAllocations()
+	c.Assert(allocs, HasLen, 1)
+	c.Assert(strings.Contains(allocs[0].String(), "TestAdopt"), IsTrue, Commentf("%s", allocs[0]))
+	c.Assert(strings.Contains(allocs[0].String(), "Adopt("), IsFalse, Commentf("%s", allocs[0]))
+}
+
+func (s *testBytesPoolSuite) TestAdoptStats(c *C) {
+	src, err := NewBytesPoolWithConfig(kilo, 4*kilo)
+	c.Assert(err, IsNil)
+	dst, err := NewBytesPoolWithConfig(kilo, 8*kilo)
+	c.Assert(err, IsNil)
+
+	origin, _ := src.Alloc(3 * kilo)
+	c.Assert(src.Stats().Buckets[2].Live, Equals, int64(1))
+	c.Assert(dst.Adopt(src, origin), IsTrue)
+	c.Assert(src.Stats().Buckets[2].Live, Equals, int64(0))
+	c.Assert(src.Stats().LiveBytes(), Equals, int64(0))
+	c.Assert(dst.Stats().Buckets[2].Live, Equals, int64(1))
+	c.Assert(dst.Stats().LiveBytes(), Equals, int64(4*kilo))
+	c.Assert(dst.Free(origin), Equals, 2)
+	c.Assert(src.Stats().LiveBytes(), Equals, int64(0))
+	c.Assert(dst.Stats().Buckets[2].Live, Equals, int64(0))
+	c.Assert(dst.Stats().LiveBytes(), Equals, int64(0))
+
+	// A large object of src is a bucket size of dst.
+	large, err := NewBytesPoolWithConfig(kilo, 4*kilo, WithLargeObjectPool(8*kilo))
+	c.Assert(err, IsNil)
+	origin, _ = large.Alloc(8 * kilo)
+	c.Assert(dst.Adopt(large, origin), IsTrue)
+	c.Assert(large.Stats().LiveBytes(), Equals, int64(0))
+	c.Assert(dst.Stats().Buckets[3].Live, Equals, int64(1))
+	c.Assert(dst.Free(origin), Equals, 3)
+	c.Assert(dst.Stats().LiveBytes(), Equals, int64(0))
+}
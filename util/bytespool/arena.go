@@ -0,0 +1,68 @@
+// Copyright 2017 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bytespool
+
+import (
+	"sync/atomic"
+)
+
+// arena is a bump allocator over a reserved bytes for the buckets if WithArena is used.
+// The reserved bytes hold no pointers, so GC never scans them, and they are never freed while the pool is alive.
+// The carved bytes are never given back to the arena, they are reused by the free lists of the buckets.
+type arena struct {
+	// off is the offset of the unused bytes, it is accessed atomically.
+	off int64
+	buf []byte
+}
+
+func newArena(size int) *arena {
+	return &arena{buf: make([]byte, size)}
+}
+
+// alloc carves n bytes from the arena, it returns nil if the arena doesn't have n bytes left.
+func (a *arena) alloc(n int) []byte {
+	// off only moves forward when the bytes fit, so the carved bytes never overlap.
+	for {
+		start := atomic.LoadInt64(&a.off)
+		end := start + int64(n)
+		if end > int64(len(a.buf)) {
+			return nil
+		}
+		if atomic.CompareAndSwapInt64(&a.off, start, end) {
+			return a.buf[start:end:end]
+		}
+	}
+}
+
+func (a *arena) remaining() int {
+	return len(a.buf) - int(atomic.LoadInt64(&a.off))
+}
+
+// newBucketBytes creates n bytes for a bucket, from the arena if WithArena is used and it has room.
+func (bp *BytesPool) newBucketBytes(n int) []byte {
+	if bp.arena != nil {
+		if b := bp.arena.alloc(n); b != nil {
+			return b
+		}
+	}
+	return bp.newBytes(n)
+}
+
+// ArenaRemaining returns the bytes left in the arena of WithArena, it is 0 if the arena is not used.
+func (bp *BytesPool) ArenaRemaining() int {
+	if bp.arena == nil {
+		return 0
+	}
+	return bp.arena.remaining()
+}
@@ -0,0 +1,121 @@
+// Copyright 2017 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bytespool
+
+import (
+	"sync"
+	"unsafe"
+
+	. "github.com/pingcap/check"
+)
+
+func (s *testBytesPoolSuite) TestArena(c *C) {
+	for _, opts := range [][]Option{
+		{WithArena(-1), WithRetain()},
+		{WithArena(mega)},
+		{WithArena(mega), WithRetain(), WithFinalizerSafetyNet()},
+	} {
+		_, err := NewBytesPoolWithOptions(opts...)
+		c.Assert(err, NotNil)
+	}
+	c.Assert(NewBytesPool().ArenaRemaining(), Equals, 0)
+
+	bp, err := NewBytesPoolWithConfig(kilo, 8*kilo, WithArena(16*kilo), WithRetain())
+	c.Assert(err, IsNil)
+	inArena := func(b []byte) bool {
+		p := uintptr(unsafe.Pointer(&b[0]))
+		start := uintptr(unsafe.Pointer(&bp.arena.buf[0]))
+		return p >= start && p+uintptr(cap(b)) <= start+uintptr(len(bp.arena.buf))
+	}
+	var origins [][]byte
+	for i := 0; i < 4; i++ {
+		origin, _ := bp.Alloc(4 * kilo)
+		c.Assert(inArena(origin), IsTrue)
+		c.Assert(cap(origin), Equals, 4*kilo)
+		origins = append(origins, origin)
+	}
+	c.Assert(bp.ArenaRemaining(), Equals, 0)
+	// The arena is used up, the new bytes are created on the heap.
+	origin, _ := bp.Alloc(kilo)
+	c.Assert(inArena(origin), IsFalse)
+	c.Assert(bp.Free(origin), Equals, 0)
+
+	// The carved bytes are reused by the free list.
+	c.Assert(bp.FreeMany(origins), Equals, 4)
+	for i := 0; i < 4; i++ {
+		origin, _ := bp.Alloc(3 * kilo)
+		c.Assert(inArena(origin), IsTrue)
+	}
+	c.Assert(bp.Stats().Misses, Equals, int64(5))
+}
+
+func (s *testBytesPoolSuite) TestArenaConcurrent(c *C) {
+	a := newArena(64 * kilo)
+	var wg sync.WaitGroup
+	carved := make([][][]byte, 8)
+	for i := range carved {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for b := a.alloc(kilo); b != nil; b = a.alloc(kilo) {
+				carved[i] = append(carved[i], b)
+			}
+		}(i)
+	}
+	wg.Wait()
+	seen := make(map[uintptr]bool)
+	for _, bs := range carved {
+		for _, b := range bs {
+			p := uintptr(unsafe.Pointer(&b[0]))
+			c.Assert(seen[p], IsFalse)
+			seen[p] = true
+		}
+	}
+	c.Assert(seen, HasLen, 64)
+	c.Assert(a.remaining(), Equals, 0)
+}
+
+func (s *testBytesPoolSuite) TestArenaConcurrentMixedSizes(c *C) {
+	// The failed allocations of the large sizes must not hand out the tail to two small ones.
+	a := newArena(64*kilo + 100)
+	base := uintptr(unsafe.Pointer(&a.buf[0]))
+	var wg sync.WaitGroup
+	carved := make([][][]byte, 8)
+	for i := range carved {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for _, n := range []int{3 * kilo, kilo, 100, 10, 3 * kilo, 1} {
+				for b := a.alloc(n); b != nil; b = a.alloc(n) {
+					carved[i] = append(carved[i], b)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	used := make([]bool, len(a.buf))
+	total := 0
+	for _, bs := range carved {
+		for _, b := range bs {
+			start := int(uintptr(unsafe.Pointer(&b[0])) - base)
+			for j := start; j < start+len(b); j++ {
+				c.Assert(used[j], IsFalse)
+				used[j] = true
+			}
+			total += len(b)
+		}
+	}
+	c.Assert(total, Equals, len(a.buf))
+	c.Assert(a.remaining(), Equals, 0)
+}
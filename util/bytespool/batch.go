@@ -0,0 +1,51 @@
+// Copyright 2017 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bytespool
+
+// AllocMany allocates bytes for every size like Alloc, origins[i] and datas[i] are for sizes[i].
+// The bucket index is reused when the same size repeats.
+func (bp *BytesPool) AllocMany(sizes []int) (origins, datas [][]byte) {
+	bp.checkOpen()
+	origins = make([][]byte, len(sizes))
+	datas = make([][]byte, len(sizes))
+	lastSize, i := 0, 0
+	for j, size := range sizes {
+		if size <= 0 || size > bp.maxSize || size < bp.opts.heapBelow || bp.slab != nil && size <= bp.slab.threshold {
+			origins[j], datas[j] = bp.alloc(size, 1)
+			continue
+		}
+		if bp.sizes != nil {
+			bp.recordSize(size)
+		}
+		if size != lastSize {
+			lastSize, i = size, bp.allocIdx(size)
+		}
+		if bp.opts.budget > 0 {
+			bp.addLive(int64(bp.bucketSize(i)))
+		}
+		origins[j], datas[j] = bp.get(i, size, 1)
+	}
+	return
+}
+
+// FreeMany frees every origin bytes like Return, it returns the number of bytes put back to the pool.
+func (bp *BytesPool) FreeMany(origins [][]byte) int {
+	n := 0
+	for _, origin := range origins {
+		if bp.Return(origin) {
+			n++
+		}
+	}
+	return n
+}
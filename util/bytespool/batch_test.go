@@ -0,0 +1,38 @@
+// Copyright 2017 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bytespool
+
+import (
+	. "github.com/pingcap/check"
+)
+
+func (s *testBytesPoolSuite) TestAllocManyFreeMany(c *C) {
+	bp, err := NewBytesPoolWithConfig(kilo, 4*kilo, WithBudget(mega), WithLeakTracking())
+	c.Assert(err, IsNil)
+	sizes := []int{100, 100, 0, 3 * kilo, 3 * kilo, 5 * kilo, kilo}
+	origins, datas := bp.AllocMany(sizes)
+	c.Assert(origins, HasLen, len(sizes))
+	for i, size := range sizes {
+		c.Assert(len(datas[i]), Equals, size)
+		c.Assert(len(origins[i]), Equals, []int{kilo, kilo, 0, 4 * kilo, 4 * kilo, 0, kilo}[i])
+	}
+	c.Assert(bp.LiveBytes(), Equals, int64(11*kilo))
+	c.Assert(bp.OutstandingAllocations(), HasLen, 5)
+
+	c.Assert(bp.FreeMany(origins), Equals, 5)
+	c.Assert(bp.LiveBytes(), Equals, int64(0))
+	c.Assert(bp.OutstandingAllocations(), HasLen, 0)
+	// The nil origins are ignored.
+	c.Assert(bp.Stats().FreeRejections, Equals, int64(0))
+}
@@ -0,0 +1,79 @@
+// Copyright 2017 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bytespool
+
+import (
+	"fmt"
+	"testing"
+)
+
+// benchModes are the pool modes compared by the benchmarks below. The sync.Pool mode boxes
+// a slice header on every Free, the retain mode doesn't allocate but takes a mutex per bucket.
+var benchModes = []struct {
+	name string
+	opts []Option
+}{
+	{"syncpool", nil},
+	{"retain", []Option{WithRetain()}},
+}
+
+// benchSizes covers the buckets from the smallest to the largest of the default pool.
+var benchSizes = []int{kilo, 16 * kilo, 256 * kilo, 4 * mega, 64 * mega}
+
+func benchmarkModes(b *testing.B, fn func(b *testing.B, bp *BytesPool, size int)) {
+	for _, mode := range benchModes {
+		for _, size := range benchSizes {
+			bp, err := NewBytesPoolWithOptions(mode.opts...)
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.Run(fmt.Sprintf("%s/%dK", mode.name, size/kilo), func(b *testing.B) {
+				b.ReportAllocs()
+				fn(b, bp, size)
+			})
+		}
+	}
+}
+
+func BenchmarkAllocFree(b *testing.B) {
+	benchmarkModes(b, func(b *testing.B, bp *BytesPool, size int) {
+		for i := 0; i < b.N; i++ {
+			origin, _ := bp.Alloc(size)
+			bp.Free(origin)
+		}
+	})
+}
+
+func BenchmarkAllocFreeParallel(b *testing.B) {
+	benchmarkModes(b, func(b *testing.B, bp *BytesPool, size int) {
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				origin, _ := bp.Alloc(size)
+				bp.Free(origin)
+			}
+		})
+	})
+}
+
+func BenchmarkAllocOversized(b *testing.B) {
+	bp, err := NewBytesPoolWithConfig(kilo, 64*kilo)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		origin, _ := bp.Alloc(128 * kilo)
+		bp.Free(origin)
+	}
+}
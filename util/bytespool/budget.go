@@ -0,0 +1,187 @@
+// Copyright 2017 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bytespool
+
+import (
+	"sync"
+	"sync/atomic"
+
+	goctx "golang.org/x/net/context"
+
+	"github.com/juju/errors"
+)
+
+// NewBytesPoolWithBudget creates a new bytes pool with the default sizes,
+// whose pooled bytes in use are limited to maxBytes for TryAlloc.
+func NewBytesPoolWithBudget(maxBytes int64, opts ...Option) (*BytesPool, error) {
+	if maxBytes <= 0 {
+		return nil, errors.Errorf("invalid budget %d, should be positive", maxBytes)
+	}
+	return NewBytesPoolWithOptions(append(opts, WithBudget(maxBytes))...)
+}
+
+// TryAlloc is like Alloc, but it returns false without allocating
+// if the allocation would make the bytes in use exceed the budget.
+// The allocations larger than the max size are not accounted unless the large object pool is enabled,
+// they are only rejected if they don't fit in the remaining budget.
+// It always succeeds for pools without a budget.
+func (bp *BytesPool) TryAlloc(size int) (origin, data []byte, ok bool) {
+	return bp.tryAlloc(size, 1)
+}
+
+// tryAlloc is TryAlloc, skip is the number of its callers in the pool.
+func (bp *BytesPool) tryAlloc(size, skip int) (origin, data []byte, ok bool) {
+	bp.checkOpen()
+	budget := bp.opts.budget
+	if budget <= 0 || size <= 0 || size < bp.opts.heapBelow || bp.slab != nil && size <= bp.slab.threshold {
+		origin, data = bp.alloc(size, skip+1)
+		return origin, data, true
+	}
+	if bp.sizes != nil {
+		bp.recordSize(size)
+	}
+	if size > bp.maxSize {
+		if bp.opts.largeGranularity <= 0 {
+			if int64(size) > budget-atomic.LoadInt64(&bp.liveBytes) {
+				return nil, nil, false
+			}
+			atomic.AddInt64(&bp.oversized, 1)
+			if bp.opts.allocHook != nil {
+				bp.opts.allocHook(size, -1)
+			}
+			return nil, make([]byte, size), true
+		}
+		n := bp.largeSize(size)
+		if !bp.reserve(int64(n)) {
+			return nil, nil, false
+		}
+		atomic.AddInt64(&bp.oversized, 1)
+		origin, data = bp.getLarge(n, size, skip+1)
+		return origin, data, true
+	}
+	i := bp.allocIdx(size)
+	if !bp.reserve(int64(bp.bucketSize(i))) {
+		return nil, nil, false
+	}
+	origin, data = bp.get(i, size, skip+1)
+	return origin, data, true
+}
+
+// reserve adds n to the live bytes if it doesn't exceed the budget.
+func (bp *BytesPool) reserve(n int64) bool {
+	for {
+		live := atomic.LoadInt64(&bp.liveBytes)
+		if n > bp.opts.budget-live {
+			return false
+		}
+		if atomic.CompareAndSwapInt64(&bp.liveBytes, live, live+n) {
+			bp.updatePeak(live + n)
+			return true
+		}
+	}
+}
+
+// LiveBytes returns the size of pooled bytes in use, which are allocated but not freed yet.
+// It is only accounted for pools with a budget, otherwise it returns 0.
+func (bp *BytesPool) LiveBytes() int64 {
+	return atomic.LoadInt64(&bp.liveBytes)
+}
+
+// addLive adds n to the live bytes and updates the peak.
+func (bp *BytesPool) addLive(n int64) {
+	bp.updatePeak(atomic.AddInt64(&bp.liveBytes, n))
+}
+
+// updatePeak raises the peak of the live bytes to live if it is higher.
+func (bp *BytesPool) updatePeak(live int64) {
+	for {
+		peak := atomic.LoadInt64(&bp.peakLiveBytes)
+		if live <= peak || atomic.CompareAndSwapInt64(&bp.peakLiveBytes, peak, live) {
+			return
+		}
+	}
+}
+
+// PeakLiveBytes returns the high-water mark of LiveBytes since the pool is created or ResetPeakLiveBytes is called,
+// which is how much memory the pool needs at worst. It is only accounted for pools with a budget, otherwise it returns 0.
+func (bp *BytesPool) PeakLiveBytes() int64 {
+	return atomic.LoadInt64(&bp.peakLiveBytes)
+}
+
+// ResetPeakLiveBytes resets the peak to the current live bytes and returns the old peak,
+// so the peaks of intervals can be measured.
+func (bp *BytesPool) ResetPeakLiveBytes() int64 {
+	return atomic.SwapInt64(&bp.peakLiveBytes, atomic.LoadInt64(&bp.liveBytes))
+}
+
+// AllocContext is like TryAlloc, but it waits for the bytes in use to be freed
+// if the allocation would exceed the budget, until ctx is done, and ctx.Err() is returned then.
+// An error is returned immediately if size never fits in the budget.
+// It never blocks for pools without a budget.
+func (bp *BytesPool) AllocContext(ctx goctx.Context, size int) (origin, data []byte, err error) {
+	if bp.opts.budget > 0 && int64(bp.Capacity(size)) > bp.opts.budget {
+		return nil, nil, errors.Errorf("allocate %d bytes exceeds the budget %d", size, bp.opts.budget)
+	}
+	for {
+		// Subscribe before trying, so the bytes freed in between are not missed.
+		ch := bp.budgetWaiters.subscribe()
+		origin, data, ok := bp.tryAlloc(size, 1)
+		if ok {
+			bp.budgetWaiters.unsubscribe()
+			return origin, data, nil
+		}
+		select {
+		case <-ch:
+			bp.budgetWaiters.unsubscribe()
+		case <-ctx.Done():
+			bp.budgetWaiters.unsubscribe()
+			return nil, nil, ctx.Err()
+		}
+	}
+}
+
+// budgetNotifier broadcasts to the waiters by closing the channel they are waiting on.
+type budgetNotifier struct {
+	// waiters is accessed atomically, so broadcast is cheap if there are no waiters.
+	waiters int32
+	mu      sync.Mutex
+	ch      chan struct{}
+}
+
+func (n *budgetNotifier) subscribe() <-chan struct{} {
+	atomic.AddInt32(&n.waiters, 1)
+	n.mu.Lock()
+	if n.ch == nil {
+		n.ch = make(chan struct{})
+	}
+	ch := n.ch
+	n.mu.Unlock()
+	return ch
+}
+
+func (n *budgetNotifier) unsubscribe() {
+	atomic.AddInt32(&n.waiters, -1)
+}
+
+func (n *budgetNotifier) broadcast() {
+	if atomic.LoadInt32(&n.waiters) == 0 {
+		return
+	}
+	n.mu.Lock()
+	if n.ch != nil {
+		close(n.ch)
+		n.ch = nil
+	}
+	n.mu.Unlock()
+}
@@ -0,0 +1,133 @@
+// Copyright 2017 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bytespool
+
+import (
+	"time"
+
+	. "github.com/pingcap/check"
+	goctx "golang.org/x/net/context"
+)
+
+func (s *testBytesPoolSuite) TestBudget(c *C) {
+	_, err := NewBytesPoolWithBudget(0)
+	c.Assert(err, NotNil)
+	_, err = NewBytesPoolWithOptions(WithBudget(-1))
+	c.Assert(err, NotNil)
+
+	bp, err := NewBytesPoolWithBudget(4 * kilo)
+	c.Assert(err, IsNil)
+	origin1, data, ok := bp.TryAlloc(100)
+	c.Assert(ok, IsTrue)
+	c.Assert(len(data), Equals, 100)
+	c.Assert(bp.LiveBytes(), Equals, int64(kilo))
+	origin2, _, ok := bp.TryAlloc(2 * kilo)
+	c.Assert(ok, IsTrue)
+	c.Assert(bp.LiveBytes(), Equals, int64(3*kilo))
+
+	// Exceeds the budget.
+	_, _, ok = bp.TryAlloc(2 * kilo)
+	c.Assert(ok, IsFalse)
+	_, _, ok = bp.TryAlloc(129 * mega)
+	c.Assert(ok, IsFalse)
+	c.Assert(bp.LiveBytes(), Equals, int64(3*kilo))
+
+	// Alloc ignores the budget but it's accounted.
+	origin3, _ := bp.Alloc(2 * kilo)
+	c.Assert(bp.LiveBytes(), Equals, int64(5*kilo))
+	_, _, ok = bp.TryAlloc(100)
+	c.Assert(ok, IsFalse)
+
+	bp.Free(origin3)
+	bp.Free(origin2)
+	c.Assert(bp.LiveBytes(), Equals, int64(kilo))
+	_, _, ok = bp.TryAlloc(3 * kilo)
+	c.Assert(ok, IsFalse)
+	_, _, ok = bp.TryAlloc(2 * kilo)
+	c.Assert(ok, IsTrue)
+	c.Assert(bp.LiveBytes(), Equals, int64(3*kilo))
+	bp.Free(origin1)
+	c.Assert(bp.LiveBytes(), Equals, int64(2*kilo))
+
+	// Pools without a budget never fail.
+	bp = NewBytesPool()
+	_, _, ok = bp.TryAlloc(129 * mega)
+	c.Assert(ok, IsTrue)
+	c.Assert(bp.LiveBytes(), Equals, int64(0))
+}
+
+func (s *testBytesPoolSuite) TestAllocContext(c *C) {
+	bp, err := NewBytesPoolWithBudget(4 * kilo)
+	c.Assert(err, IsNil)
+	origin1, data, err := bp.AllocContext(goctx.Background(), 3*kilo)
+	c.Assert(err, IsNil)
+	c.Assert(len(data), Equals, 3*kilo)
+
+	// Never fits.
+	_, _, err = bp.AllocContext(goctx.Background(), 5*kilo)
+	c.Assert(err, NotNil)
+
+	// Times out.
+	ctx, cancel := goctx.WithTimeout(goctx.Background(), 10*time.Millisecond)
+	_, _, err = bp.AllocContext(ctx, kilo)
+	cancel()
+	c.Assert(err, Equals, goctx.DeadlineExceeded)
+	c.Assert(bp.LiveBytes(), Equals, int64(4*kilo))
+
+	// Wakes up by Free.
+	done := make(chan error, 1)
+	go func() {
+		origin, _, err := bp.AllocContext(goctx.Background(), 2*kilo)
+		if err == nil {
+			bp.Free(origin)
+		}
+		done <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	bp.Free(origin1)
+	c.Assert(<-done, IsNil)
+	c.Assert(bp.LiveBytes(), Equals, int64(0))
+
+	// Pools without a budget never block.
+	bp = NewBytesPool()
+	origin, _, err := bp.AllocContext(goctx.Background(), 129*mega)
+	c.Assert(err, IsNil)
+	c.Assert(origin, IsNil)
+}
+
+func (s *testBytesPoolSuite) TestPeakLiveBytes(c *C) {
+	c.Assert(NewBytesPool().PeakLiveBytes(), Equals, int64(0))
+
+	bp, err := NewBytesPoolWithConfig(kilo, 4*kilo, WithBudget(mega), WithLargeObjectPool(kilo))
+	c.Assert(err, IsNil)
+	a, _ := bp.Alloc(kilo)
+	b, _ := bp.Alloc(3 * kilo)
+	c.Assert(bp.PeakLiveBytes(), Equals, int64(5*kilo))
+	bp.Free(b)
+	c.Assert(bp.PeakLiveBytes(), Equals, int64(5*kilo))
+	b, _, ok := bp.TryAlloc(2 * kilo)
+	c.Assert(ok, IsTrue)
+	c.Assert(bp.PeakLiveBytes(), Equals, int64(5*kilo))
+	d, _ := bp.Alloc(5 * kilo)
+	c.Assert(bp.PeakLiveBytes(), Equals, int64(8*kilo))
+	c.Assert(bp.Stats().PeakLiveBytes, Equals, int64(8*kilo))
+
+	// The peak of the next interval starts from the current live bytes.
+	bp.Free(d)
+	c.Assert(bp.ResetPeakLiveBytes(), Equals, int64(8*kilo))
+	c.Assert(bp.PeakLiveBytes(), Equals, int64(3*kilo))
+	bp.Free(a)
+	bp.Free(b)
+	c.Assert(bp.PeakLiveBytes(), Equals, int64(3*kilo))
+}
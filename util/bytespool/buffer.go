@@ -0,0 +1,132 @@
+// Copyright 2017 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bytespool
+
+import (
+	"io"
+)
+
+// PooledBuffer is a variable-sized buffer of bytes like bytes.Buffer,
+// but its storage is allocated from a BytesPool and promoted to a larger bucket when it is full.
+// It is not thread-safe, and it should be closed to return the storage to the pool.
+type PooledBuffer struct {
+	pool   *BytesPool
+	origin []byte
+	buf    []byte
+}
+
+// NewPooledBuffer creates a PooledBuffer whose initial storage can hold size bytes.
+func NewPooledBuffer(pool *BytesPool, size int) *PooledBuffer {
+	origin, data := pool.Alloc(size)
+	return &PooledBuffer{
+		pool:   pool,
+		origin: origin,
+		buf:    data[:0],
+	}
+}
+
+// grow makes sure the buffer has room for another n bytes.
+func (b *PooledBuffer) grow(n int) {
+	l := len(b.buf)
+	if l+n <= cap(b.buf) {
+		return
+	}
+	newSize := 2 * cap(b.buf)
+	if newSize < l+n {
+		newSize = l + n
+	}
+	var data []byte
+	b.origin, data = b.pool.Realloc(b.origin, b.buf, newSize)
+	b.buf = data[:l]
+}
+
+// Write implements io.Writer interface, it never returns an error.
+func (b *PooledBuffer) Write(p []byte) (int, error) {
+	b.grow(len(p))
+	b.buf = append(b.buf, p...)
+	return len(p), nil
+}
+
+// WriteString appends the content of s to the buffer, it never returns an error.
+func (b *PooledBuffer) WriteString(s string) (int, error) {
+	b.grow(len(s))
+	b.buf = append(b.buf, s...)
+	return len(s), nil
+}
+
+// WriteByte implements io.ByteWriter interface, it never returns an error.
+func (b *PooledBuffer) WriteByte(c byte) error {
+	b.grow(1)
+	b.buf = append(b.buf, c)
+	return nil
+}
+
+// minRead is the minimal room ReadFrom makes for a Read call.
+const minRead = 512
+
+// ReadFrom implements io.ReaderFrom interface, it reads from r into the room of the storage directly
+// until io.EOF, and promotes the storage only when it is full. io.EOF is not returned as an error,
+// and the other errors of r are returned as is.
+func (b *PooledBuffer) ReadFrom(r io.Reader) (int64, error) {
+	var total int64
+	for {
+		if cap(b.buf)-len(b.buf) < minRead {
+			b.grow(minRead)
+		}
+		l := len(b.buf)
+		n, err := r.Read(b.buf[l:cap(b.buf)])
+		b.buf = b.buf[:l+n]
+		total += int64(n)
+		if err == io.EOF {
+			return total, nil
+		}
+		if err != nil {
+			return total, err
+		}
+	}
+}
+
+// Bytes returns the content of the buffer.
+// It aliases the pooled storage, so it is only valid until the next write or Close.
+func (b *PooledBuffer) Bytes() []byte {
+	return b.buf
+}
+
+// Len returns the number of bytes in the buffer.
+func (b *PooledBuffer) Len() int {
+	return len(b.buf)
+}
+
+// Reset empties the buffer but keeps the storage for future writes.
+func (b *PooledBuffer) Reset() {
+	b.buf = b.buf[:0]
+}
+
+// Detach hands the storage over to the caller and empties the buffer, data is the content of the buffer.
+// The caller owns origin and should free it to the pool after using data, closing the buffer doesn't free it.
+func (b *PooledBuffer) Detach() (origin, data []byte) {
+	origin, data = b.origin, b.buf
+	b.origin, b.buf = nil, nil
+	return origin, data
+}
+
+// Close returns the storage to the pool and empties the buffer.
+// Writing after Close allocates a new storage, which should be closed again.
+func (b *PooledBuffer) Close() error {
+	if b.origin != nil {
+		b.pool.Free(b.origin)
+	}
+	b.origin, b.buf = nil, nil
+	return nil
+}
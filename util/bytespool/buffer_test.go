@@ -0,0 +1,115 @@
+// Copyright 2017 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bytespool
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	. "github.com/pingcap/check"
+)
+
+var _ io.Writer = &PooledBuffer{}
+
+func (s *testBytesPoolSuite) TestPooledBuffer(c *C) {
+	bp, err := NewBytesPoolWithConfig(kilo, 4*kilo, WithLeakTracking())
+	c.Assert(err, IsNil)
+	b := NewPooledBuffer(bp, 0)
+	c.Assert(b.Len(), Equals, 0)
+
+	var expect bytes.Buffer
+	for i := 0; i < 1000; i++ {
+		fmt.Fprintf(b, "%d,", i)
+		fmt.Fprintf(&expect, "%d,", i)
+		b.WriteString("s")
+		expect.WriteString("s")
+		b.WriteByte('b')
+		expect.WriteByte('b')
+	}
+	c.Assert(b.Len(), Equals, expect.Len())
+	c.Assert(b.Bytes(), DeepEquals, expect.Bytes())
+	// The buffer grows beyond the max size of the pool.
+	c.Assert(b.Len() > 4*kilo, IsTrue)
+	c.Assert(bp.OutstandingAllocations(), HasLen, 0)
+	c.Assert(bp.Stats().Frees, Equals, int64(3))
+
+	b.Reset()
+	c.Assert(b.Len(), Equals, 0)
+	b.WriteString("hello")
+	c.Assert(string(b.Bytes()), Equals, "hello")
+	c.Assert(b.Close(), IsNil)
+	c.Assert(b.Len(), Equals, 0)
+
+	b = NewPooledBuffer(bp, 2*kilo)
+	b.WriteString("hello")
+	c.Assert(bp.OutstandingAllocations(), HasLen, 1)
+	c.Assert(b.Close(), IsNil)
+	c.Assert(bp.OutstandingAllocations(), HasLen, 0)
+
+	// The detached storage is owned by the caller.
+	rejections := bp.Stats().FreeRejections
+	b = NewPooledBuffer(bp, 0)
+	b.WriteString("hello")
+	origin, data := b.Detach()
+	c.Assert(string(data), Equals, "hello")
+	c.Assert(b.Len(), Equals, 0)
+	c.Assert(b.Close(), IsNil)
+	c.Assert(bp.OutstandingAllocations(), HasLen, 1)
+	c.Assert(bp.Free(origin), Equals, 0)
+	c.Assert(bp.OutstandingAllocations(), HasLen, 0)
+	c.Assert(bp.Stats().FreeRejections, Equals, rejections)
+}
+
+var _ io.ReaderFrom = &PooledBuffer{}
+
+type errReader struct{}
+
+func (errReader) Read(p []byte) (int, error) {
+	return 0, errors.New("read error")
+}
+
+// failReader fails every read with err.
+type failReader struct {
+	err error
+}
+
+func (r failReader) Read(p []byte) (int, error) {
+	return 0, r.err
+}
+
+func (s *testBytesPoolSuite) TestPooledBufferReadFrom(c *C) {
+	bp, err := NewBytesPoolWithConfig(kilo, 64*kilo, WithLeakTracking())
+	c.Assert(err, IsNil)
+	b := NewPooledBuffer(bp, 0)
+	b.WriteString("head,")
+	src := bytes.Repeat([]byte("0123456789"), 5*kilo)
+	n, err := b.ReadFrom(bytes.NewReader(src))
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, int64(len(src)))
+	c.Assert(b.Bytes(), DeepEquals, append([]byte("head,"), src...))
+	// The storage is promoted by doubling, 1K to 64K.
+	c.Assert(bp.Stats().Frees, Equals, int64(6))
+
+	_, err = b.ReadFrom(io.MultiReader(strings.NewReader("tail"), errReader{}))
+	c.Assert(err, ErrorMatches, "read error")
+	c.Assert(b.Len(), Equals, len(src)+len("head,tail"))
+	rerr := errors.New("read error")
+	_, err = b.ReadFrom(failReader{rerr})
+	c.Assert(err, Equals, rerr)
+	c.Assert(b.Close(), IsNil)
+	c.Assert(bp.OutstandingAllocations(), HasLen, 0)
+}
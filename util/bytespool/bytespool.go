@@ -14,77 +14,751 @@
 package bytespool
 
 import (
+	"fmt"
+	"math"
+	"math/bits"
+	"sort"
 	"sync"
+	"sync/atomic"
+	"unsafe"
+
+	"github.com/juju/errors"
 )
 
 // BytesPool maintains large bytes pools, used for reducing memory allocation.
 // It has a slice of pools which handle different size of bytes.
 // Can be safely used concurrently.
 type BytesPool struct {
-	buckets []sync.Pool
+	// The 64-bit counters are accessed atomically, keep them at the front for alignment.
+	oversized      int64
+	freeRejections int64
+	// liveBytes is the size of pooled bytes in use, it is only accounted for budgeted pools.
+	liveBytes int64
+	// peakLiveBytes is the high-water mark of liveBytes.
+	peakLiveBytes int64
+	// fragmentation is the bytes wasted by rounding the requested sizes, it is only accounted with WithSizeHistogram.
+	fragmentation int64
+	// closed is set to 1 by Close, it is accessed atomically.
+	closed int32
+
+	// shards points to a [][]sync.Pool which holds the buckets of every shard,
+	// there is only one shard if the pool is not sharded. It is replaced atomically by Clear.
+	shards     unsafe.Pointer
+	numBuckets int
+	counters   []bucketCounter
+	baseSize   int
+	// baseShift is log2(baseSize).
+	baseShift int
+	maxSize   int
+	// bucketSizes is the ascending sizes of the buckets if WithGrowthFactor is used, otherwise it is nil
+	// and the sizes are the powers of two from baseSize.
+	bucketSizes []int
+
+	opts    options
+	tracker *leakTracker
+	// safetyNet frees the leaked origins by finalizers if WithFinalizerSafetyNet is used.
+	safetyNet *safetyNet
+	// profiler counts the allocations by call site if WithCallSiteProfiling is used.
+	profiler *callSiteProfiler
+	// largePools maps the rounded size to the *sync.Pool of the large objects.
+	largePools sync.Map
+	// mmaps backs the largest buckets with anonymous mappings if WithMmapThreshold is used.
+	mmaps *mmapPool
+	// retained holds the idle bytes of the buckets instead of the shards if WithRetain is used.
+	retained []freeList
+	// arena backs the new bytes of the buckets if WithArena is used.
+	arena *arena
+	// slab carves the small allocations from pages if WithSlab is used.
+	slab *slab
+	// spills backs the huge allocations by temp files if WithDiskSpill is used.
+	spills *spillTier
+	// sizes is the histogram of the requested sizes if WithSizeHistogram is used.
+	sizes []int64
+	// trimmer trims the idle bytes in the background if WithAutoTrim is used.
+	trimmer *autoTrimmer
+	// warmer prefills the buckets in the background if WithAdaptiveWarm is used.
+	warmer *adaptiveWarmer
+	// tags counts the live bytes of the tags of AllocTagged.
+	tags tagCounters
+	// budgetWaiters wakes up AllocContext when the budgeted bytes are freed.
+	budgetWaiters budgetNotifier
 }
 
 const (
-	kilo       = 1024
-	mega       = kilo * kilo
-	baseSize   = kilo
-	numBuckets = 18
-	maxSize    = 128 * mega
+	kilo              = 1024
+	mega              = kilo * kilo
+	defaultBaseSize   = kilo
+	defaultNumBuckets = 18
+	defaultMaxSize    = 128 * mega
+
+	maxInt = int(^uint(0) >> 1)
 )
 
 // DefaultPool is a default BytesBool instance.
 var DefaultPool = NewBytesPool()
 
+// SetDefaultPool replaces DefaultPool by pool, so an application can make the library code using DefaultPool
+// respect its budget or sharding. It is not thread-safe, it should be called during init before DefaultPool is used.
+func SetDefaultPool(pool *BytesPool) {
+	DefaultPool = pool
+}
+
+// Default returns DefaultPool, the code which should follow SetDefaultPool should get the pool by it
+// instead of keeping a reference to DefaultPool.
+func Default() *BytesPool {
+	return DefaultPool
+}
+
 // NewBytesPool creates a new bytes pool.
 func NewBytesPool() *BytesPool {
-	bp := new(BytesPool)
-	bp.buckets = make([]sync.Pool, numBuckets)
-	for i := uint(0); i < numBuckets; i++ {
-		bp.buckets[i].New = makeNewFunc(i)
+	return newBytesPool(defaultBaseSize, defaultNumBuckets, options{})
+}
+
+// NewBytesPoolWithConfig creates a new bytes pool whose smallest bucket holds baseSize bytes
+// and whose largest bucket holds maxSize bytes.
+// Both sizes should be powers of two and maxSize should not be less than baseSize,
+// the number of buckets is derived from their ratio.
+// It returns an error for an invalid config, so the caller can fall back to DefaultPool.
+func NewBytesPoolWithConfig(baseSize, maxSize int, opts ...Option) (*BytesPool, error) {
+	if baseSize <= 0 || !isPowerOfTwo(baseSize) {
+		return nil, errors.Errorf("invalid base size %d, should be a power of two", baseSize)
+	}
+	if maxSize < baseSize || !isPowerOfTwo(maxSize) {
+		return nil, errors.Errorf("invalid max size %d, should be a power of two not less than base size %d", maxSize, baseSize)
+	}
+	numBuckets := 1
+	for baseSize<<uint(numBuckets-1) < maxSize {
+		numBuckets++
+	}
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if err := o.validate(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return newBytesPool(baseSize, numBuckets, o), nil
+}
+
+// NewBytesPoolWithOptions creates a new bytes pool with the default sizes and the given options.
+func NewBytesPoolWithOptions(opts ...Option) (*BytesPool, error) {
+	return NewBytesPoolWithConfig(defaultBaseSize, defaultMaxSize, opts...)
+}
+
+// newBytesPool creates a pool, numBuckets is the number of power of two buckets from baseSize,
+// which derives the max size, and the number of the WithGrowthFactor buckets is derived from the sizes again.
+func newBytesPool(baseSize, numBuckets int, opts options) *BytesPool {
+	bp := &BytesPool{
+		baseSize:  baseSize,
+		baseShift: bits.TrailingZeros(uint(baseSize)),
+		maxSize:   baseSize << uint(numBuckets-1),
+		opts:      opts,
+	}
+	if opts.growthFactor > 0 {
+		bp.bucketSizes = geometricSizes(baseSize, bp.maxSize, opts.growthFactor)
+		numBuckets = len(bp.bucketSizes)
+	}
+	if opts.tracking() {
+		bp.tracker = newLeakTracker(opts.leakTracking, opts.name)
+	}
+	if opts.finalizerSafetyNet {
+		bp.safetyNet = newSafetyNet()
+	}
+	if opts.profileDepth > 0 {
+		bp.profiler = newCallSiteProfiler(opts.profileDepth, opts.profileRate)
+	}
+	bp.numBuckets = numBuckets
+	bp.counters = make([]bucketCounter, numBuckets)
+	bp.storeShards(bp.newShards())
+	if opts.retain {
+		bp.retained = make([]freeList, numBuckets)
+	}
+	if opts.mmapThreshold > 0 && mmapSupported && opts.mmapThreshold <= bp.maxSize {
+		bp.mmaps = newMmapPool(bp.bucketIdx(opts.mmapThreshold), numBuckets, opts.numa)
+	}
+	if opts.arenaSize > 0 {
+		bp.arena = newArena(opts.arenaSize)
+	}
+	if opts.slabThreshold > 0 {
+		bp.slab = newSlab(bp, opts.slabThreshold)
+	}
+	if opts.spillThreshold > 0 && mmapSupported {
+		bp.spills = newSpillTier(opts.spillThreshold, opts.spillDir)
+	}
+	if opts.sizeHistogram {
+		bp.sizes = make([]int64, bits.UintSize+1)
+	}
+	if opts.autoTrimInterval > 0 && opts.retain {
+		bp.trimmer = newAutoTrimmer()
+		go bp.trimmer.run(bp, opts.autoTrimInterval, opts.autoTrimKeep, opts.memoryLimit)
+	}
+	if opts.register {
+		register(bp)
+	}
+	if opts.warmRatio > 0 && opts.retain {
+		bp.warmer = newAdaptiveWarmer(bp.numBuckets)
+		go bp.warmer.run(bp, adaptiveWarmInterval, opts.warmRatio)
 	}
 	return bp
 }
 
-func makeNewFunc(shift uint) func() interface{} {
-	return func() interface{} {
-		return make([]byte, baseSize<<shift)
+// Clone creates a new pool with the same configuration and options as bp, but with empty buckets and statistics.
+// The clone shares neither the pooled bytes nor the accounting with bp.
+func (bp *BytesPool) Clone() *BytesPool {
+	return newBytesPool(bp.baseSize, bits.Len(uint(bp.maxSize>>uint(bp.baseShift))), bp.opts)
+}
+
+func (bp *BytesPool) newShards() [][]sync.Pool {
+	n := bp.opts.shards
+	if bp.opts.numa {
+		n = numaNodes()
+	}
+	if n == 0 {
+		n = 1
 	}
+	shards := make([][]sync.Pool, n)
+	for i := range shards {
+		// The buckets don't set New, so an empty bucket can be told apart and drained by Trim.
+		shards[i] = make([]sync.Pool, bp.numBuckets)
+	}
+	return shards
+}
+
+func (bp *BytesPool) loadShards() [][]sync.Pool {
+	return *(*[][]sync.Pool)(atomic.LoadPointer(&bp.shards))
+}
+
+func (bp *BytesPool) storeShards(shards [][]sync.Pool) {
+	atomic.StorePointer(&bp.shards, unsafe.Pointer(&shards))
+}
+
+// bucket returns the i-th bucket, which is in the local shard in sharded mode.
+func (bp *BytesPool) bucket(i int) *sync.Pool {
+	shards := bp.loadShards()
+	if len(shards) == 1 {
+		return &shards[0][i]
+	}
+	if bp.opts.numa {
+		return &shards[currentNode()%len(shards)][i]
+	}
+	return &shards[localShard(len(shards))][i]
 }
 
 // Alloc allocates a bytes which has the size of power of two.
 // The caller should keep the origin bytes and use the returned data.
 // When finished using, the origin bytes should be freed to the pool.
 // The allocated data may not have zero value.
+// Alloc(0) returns nil without touching the buckets, and a negative size panics.
+// Sizes larger than the max size are checked before any bucket arithmetic, so sizes up to the max int
+// never overflow and just take the oversized path. Note that int is 32-bit on 32-bit platforms,
+// where a max size of 1GB is the largest power of two which fits.
 func (bp *BytesPool) Alloc(size int) (origin, data []byte) {
-	if size > maxSize {
+	return bp.alloc(size, 1)
+}
+
+// alloc is Alloc, skip is the number of its callers in the pool, so the stacks recorded by
+// the leak tracking and the profiling start from the caller of the exported method.
+func (bp *BytesPool) alloc(size, skip int) (origin, data []byte) {
+	bp.checkOpen()
+	if bp.sizes != nil {
+		bp.recordSize(size)
+	}
+	if size > bp.maxSize {
+		atomic.AddInt64(&bp.oversized, 1)
+		if bp.spills != nil && size > bp.spills.threshold {
+			if origin = bp.spills.alloc(size); origin != nil {
+				if bp.opts.allocHook != nil {
+					bp.opts.allocHook(size, -1)
+				}
+				return origin, origin
+			}
+		}
+		if bp.opts.largeGranularity <= 0 {
+			if bp.opts.allocHook != nil {
+				bp.opts.allocHook(size, -1)
+			}
+			return nil, make([]byte, size)
+		}
+		n := bp.largeSize(size)
+		if bp.opts.budget > 0 {
+			bp.addLive(int64(n))
+		}
+		return bp.getLarge(n, size, skip+1)
+	}
+	if size <= 0 {
+		checkSize(size)
+		return nil, nil
+	}
+	if size < bp.opts.heapBelow {
+		if bp.opts.allocHook != nil {
+			bp.opts.allocHook(size, -1)
+		}
 		return nil, make([]byte, size)
 	}
-	i := bucketIdx(size)
-	origin = bp.buckets[i].Get().([]byte)
-	data = origin[:size]
+	if bp.slab != nil && size <= bp.slab.threshold {
+		return bp.slab.alloc(size, skip+1)
+	}
+	i := bp.allocIdx(size)
+	if bp.opts.budget > 0 {
+		bp.addLive(int64(bp.bucketSize(i)))
+	}
+	return bp.get(i, size, skip+1)
+}
+
+// newBytes creates n bytes for a bucket or the large object pool, by the allocator if WithAllocator is used.
+func (bp *BytesPool) newBytes(n int) []byte {
+	if bp.opts.allocator != nil {
+		return bp.opts.allocator(n)
+	}
+	return make([]byte, n)
+}
+
+func checkSize(size int) {
+	if size < 0 {
+		panic("bytespool: negative size")
+	}
+}
+
+// get gets bytes from the i-th bucket, skip is the number of its callers in the pool.
+func (bp *BytesPool) get(i, size, skip int) (origin, data []byte) {
+	atomic.AddInt64(&bp.counters[i].gets, 1)
+	atomic.AddInt64(&bp.counters[i].live, 1)
+	mapped := bp.mmaps != nil && i >= bp.mmaps.from
+	if mapped {
+		var ok bool
+		if origin, ok = bp.mmaps.get(i, bp.bucketSize(i), &bp.counters[i].misses); !ok {
+			return bp.unpooled(i, size, origin)
+		}
+	} else if origin = bp.getIdle(i); origin != nil {
+		if bp.opts.maxIdle > 0 {
+			atomic.AddInt64(&bp.counters[i].idle, -1)
+		}
+	} else {
+		atomic.AddInt64(&bp.counters[i].misses, 1)
+		if bp.opts.maxIdle > 0 {
+			// The bucket may be dropped by GC, so the count is stale.
+			atomic.StoreInt64(&bp.counters[i].idle, 0)
+		}
+		origin = bp.newBucketBytes(bp.bucketSize(i))
+	}
+	if bp.tracker != nil {
+		bp.tracker.record(origin, skip+1)
+	}
+	if bp.profiler != nil {
+		bp.profiler.sample(len(origin), skip+1)
+	}
+	if bp.safetyNet != nil && !mapped {
+		bp.arm(origin)
+	}
+	if bp.opts.allocHook != nil {
+		bp.opts.allocHook(size, i)
+	}
+	return origin, origin[:size]
+}
+
+// unpooled undoes the accounting of the i-th bucket for the bytes made when mmap fails,
+// they are returned like the oversized allocations, so the nil origin is not freed to the pool.
+func (bp *BytesPool) unpooled(i, size int, b []byte) (origin, data []byte) {
+	atomic.AddInt64(&bp.counters[i].live, -1)
+	if bp.opts.budget > 0 {
+		atomic.AddInt64(&bp.liveBytes, -int64(len(b)))
+		bp.budgetWaiters.broadcast()
+	}
+	if bp.opts.allocHook != nil {
+		bp.opts.allocHook(size, -1)
+	}
+	return nil, b[:size]
+}
+
+// Name returns the name of the pool set by WithName, it is empty by default.
+func (bp *BytesPool) Name() string {
+	return bp.opts.name
+}
+
+// BucketSizes returns the size of every bucket in ascending order, the sizes Alloc rounds up to.
+func (bp *BytesPool) BucketSizes() []int {
+	sizes := make([]int, bp.numBuckets)
+	for i := range sizes {
+		sizes[i] = bp.bucketSize(i)
+	}
+	return sizes
+}
+
+// BucketSizeSet returns the sizes of BucketSizes as a set, a length is accepted by Free
+// into the buckets only if it is in the set.
+func (bp *BytesPool) BucketSizeSet() map[int]struct{} {
+	set := make(map[int]struct{}, bp.numBuckets)
+	for i := 0; i < bp.numBuckets; i++ {
+		set[bp.bucketSize(i)] = struct{}{}
+	}
+	return set
+}
+
+// Capacity returns the length of the origin bytes Alloc would return for size, without allocating.
+// It returns size unchanged for sizes larger than the max size, or the rounded size if the large object pool is enabled.
+// Callers can use it to pick a size which wastes less bucket space.
+func (bp *BytesPool) Capacity(size int) int {
+	if size <= 0 {
+		checkSize(size)
+		return 0
+	}
+	if size > bp.maxSize {
+		if bp.opts.largeGranularity <= 0 {
+			return size
+		}
+		return bp.largeSize(size)
+	}
+	if size < bp.opts.heapBelow {
+		return size
+	}
+	if bp.slab != nil && size <= bp.slab.threshold {
+		return bp.slab.classes[bp.slab.classIdx(size)].cell
+	}
+	return bp.bucketSize(bp.allocIdx(size))
+}
+
+// RoundSize returns the size of the bucket serving size, the power of two multiple of the base size
+// unless WithGrowthFactor is used, after rounding size up by WithRoundUp. Unlike Capacity, it ignores WithHeapBelow and WithSlab, it returns 0 for a non-positive size
+// and size unchanged for the sizes larger than the max size.
+func (bp *BytesPool) RoundSize(size int) int {
+	if size <= 0 {
+		return 0
+	}
+	if size > bp.maxSize {
+		return size
+	}
+	return bp.bucketSize(bp.allocIdx(size))
+}
+
+// IsPooled returns whether size is served by the buckets, that is 0 < size <= the max size.
+func (bp *BytesPool) IsPooled(size int) bool {
+	return size > 0 && size <= bp.maxSize
+}
+
+// TryAllocPooled is like Alloc, but it also reports whether the bytes are from the pool,
+// pooled is false if Alloc falls back to make, like for the sizes larger than the max size
+// without the large object pool, or if the bytes are spilled to disk, so the caller can meter or reject them.
+// The size 0 is not pooled either.
+func (bp *BytesPool) TryAllocPooled(size int) (origin, data []byte, pooled bool) {
+	origin, data = bp.alloc(size, 1)
+	pooled = origin != nil && (bp.spills == nil || !bp.spills.owns(origin))
+	return origin, data, pooled
+}
+
+// AllocForWrite is like Alloc, but buf is the whole origin bytes instead of being sliced to minSize,
+// so the caller can write up to the capacity of the bucket and reslice before use.
+// buf is exactly minSize bytes if the bytes are not from the pool. Free still takes origin.
+func (bp *BytesPool) AllocForWrite(minSize int) (origin, buf []byte) {
+	origin, buf = bp.alloc(minSize, 1)
+	if origin != nil {
+		buf = origin
+	}
+	return origin, buf
+}
+
+// AllocAppendable is like Alloc, but data is guaranteed to have the capacity of the whole origin bytes,
+// that is Capacity(size), so appending to data reuses the pooled storage until the bucket is full.
+// Appending beyond the capacity moves data to a new array on the heap, which is not pooled.
+// Free still takes origin, never the appended data.
+func (bp *BytesPool) AllocAppendable(size int) (origin, data []byte) {
+	origin, data = bp.alloc(size, 1)
+	if origin != nil {
+		data = origin[:size:cap(origin)]
+	}
+	return origin, data
+}
+
+// AllocZeroed is like Alloc, but the returned data is guaranteed to be all zero.
+// Only data is cleared, the bytes in origin beyond the size may still contain stale values.
+func (bp *BytesPool) AllocZeroed(size int) (origin, data []byte) {
+	origin, data = bp.alloc(size, 1)
+	if origin == nil {
+		// The oversized data is created by make, it is already zero.
+		return
+	}
+	// The loop is recognized by the compiler and replaced with memclr.
+	for i := range data {
+		data[i] = 0
+	}
+	return
+}
+
+// AllocAligned is like Alloc, but the address of the returned data is a multiple of alignment,
+// which should be a power of two. It over-allocates alignment-1 bytes and reslices data from origin,
+// so the caller must keep the returned origin for Free, instead of the aligned data.
+// The aligned data can't be passed to Realloc, which assumes data starts at origin.
+func (bp *BytesPool) AllocAligned(size, alignment int) (origin, data []byte) {
+	if alignment <= 0 || !isPowerOfTwo(alignment) {
+		panic(fmt.Sprintf("bytespool: alignment %d is not a power of two", alignment))
+	}
+	if size <= 0 {
+		checkSize(size)
+		return nil, nil
+	}
+	origin, data = bp.alloc(size+alignment-1, 1)
+	buf := data[:cap(data)]
+	off := int(-uintptr(unsafe.Pointer(&buf[0])) & uintptr(alignment-1))
+	return origin, buf[off : off+size]
+}
+
+// Realloc resizes the data allocated by Alloc to newSize, the content of data is preserved.
+// If newSize fits in origin, data is just resliced, otherwise a larger bytes is allocated
+// and origin is freed to the pool.
+// The caller should keep the returned newOrigin and use the returned newData instead of the old ones.
+func (bp *BytesPool) Realloc(origin, data []byte, newSize int) (newOrigin, newData []byte) {
+	if origin == nil {
+		if newSize <= cap(data) {
+			return nil, data[:newSize]
+		}
+	} else if newSize <= len(origin) {
+		return origin, origin[:newSize]
+	}
+	newOrigin, newData = bp.alloc(newSize, 1)
+	copy(newData, data)
+	if origin != nil {
+		bp.Free(origin)
+	}
 	return
 }
 
+// Reuse returns origin resliced to size if it fits, otherwise origin is freed and new bytes are allocated for size.
+// It is like Realloc without preserving the content, so a worker can keep a hot buffer across iterations.
+// The caller should use the returned newOrigin for the subsequent Reuse or Free calls.
+func (bp *BytesPool) Reuse(origin []byte, size int) (newOrigin, data []byte) {
+	if origin != nil && size <= len(origin) {
+		checkSize(size)
+		return origin, origin[:size]
+	}
+	if origin != nil {
+		bp.Free(origin)
+	}
+	return bp.alloc(size, 1)
+}
+
 // Free frees the data which should be the original bytes return by Alloc.
 // It returns the bucket index of the data. returns -1 means the data is not returned to the pool.
+// Only the bytes whose length is a bucket size, a power of two between the base size and the max size inclusive
+// unless WithGrowthFactor is used, are pooled,
+// or a multiple of the granularity larger than the max size if the large object pool is enabled,
+// other lengths, including 0, are rejected. A nil origin, which Alloc returns for the bytes not from the pool,
+// is ignored without being counted as a rejection.
+// The origin resliced to a shorter length is rejected and leaks the bucket bytes, free it by FreeWithCap,
+// or use WithReslicedFreeCheck to catch the mistake.
+// Always free the origin returned by Alloc, never data grown by append, which may be a new array on the heap
+// with a valid bucket length and would be handed out as pooled bytes later, WithDoubleFreeCheck catches it.
+// The bytes returned to the large object pool get the index of the number of buckets.
+// New code should prefer Return, the bucket index is only kept for backward compatibility.
 func (bp *BytesPool) Free(origin []byte) int {
-	originLen := len(origin)
-	if originLen > maxSize || originLen < baseSize || !isPowerOfTwo(originLen) {
+	if !bp.Return(origin) {
 		return -1
 	}
-	i := bucketIdx(originLen)
-	bp.buckets[i].Put(origin)
-	return i
+	if len(origin) > bp.maxSize {
+		return bp.numBuckets
+	}
+	// The cells of the slab get 0 as well.
+	return bp.bucketIdx(len(origin))
+}
+
+// FreeWithCap is like Free, but it finds the bucket by cap(buf) instead of len(buf),
+// so the origin bytes can be freed after being resliced to a shorter length, like origin[:10].
+// buf must start at the beginning of the origin bytes, and its cap is validated as Free validates the length.
+func (bp *BytesPool) FreeWithCap(buf []byte) int {
+	return bp.Free(buf[:cap(buf)])
+}
+
+// Return frees the origin bytes returned by Alloc to the pool.
+// It returns true if the bytes are put back to the pool, false if they are not pooled.
+func (bp *BytesPool) Return(origin []byte) bool {
+	if origin == nil {
+		return false
+	}
+	originLen := len(origin)
+	if originLen > bp.maxSize {
+		if bp.spills != nil && bp.spills.free(origin) {
+			return false
+		}
+		if !bp.isLargeSize(originLen) {
+			bp.reject(origin)
+			return false
+		}
+		bp.release(origin, bp.numBuckets)
+		if bp.isClosed() {
+			return false
+		}
+		bp.putLarge(origin)
+		return true
+	}
+	if originLen < bp.baseSize && bp.slab != nil {
+		if bp.slab.free(origin) {
+			return true
+		}
+		if bp.opts.doubleFreeCheck && bp.slab.isCellSize(originLen) {
+			panicNotAllocated(origin)
+		}
+	}
+	if bp.opts.reslicedCheck && cap(origin) != originLen && bp.isBucketSize(cap(origin)) {
+		panic(fmt.Sprintf("bytespool: free %d bytes at %#x resliced from a %d bytes bucket, use FreeWithCap instead",
+			originLen, bytesPointer(origin), cap(origin)))
+	}
+	// The length should be exactly one of the bucket sizes of this pool, so the bytes of a pool
+	// with another config are only accepted if they fit a bucket here.
+	if !bp.isBucketSize(originLen) {
+		bp.reject(origin)
+		return false
+	}
+	i := bp.bucketIdx(originLen)
+	mapped := bp.mmaps != nil && i >= bp.mmaps.from
+	if mapped && !bp.mmaps.owns(origin) {
+		bp.reject(origin)
+		return false
+	}
+	bp.release(origin, i)
+	if bp.isClosed() {
+		if mapped {
+			bp.mmaps.discard(origin)
+		}
+		return false
+	}
+	if !mapped && !bp.admitIdle(i) {
+		atomic.AddInt64(&bp.freeRejections, 1)
+		return false
+	}
+	atomic.AddInt64(&bp.counters[i].frees, 1)
+	if mapped {
+		bp.mmaps.put(i, origin)
+	} else {
+		bp.putIdle(i, origin)
+	}
+	return true
+}
+
+// isBucketSize returns whether size is the size of a bucket.
+func (bp *BytesPool) isBucketSize(size int) bool {
+	if bp.bucketSizes != nil {
+		i := sort.SearchInts(bp.bucketSizes, size)
+		return i < len(bp.bucketSizes) && bp.bucketSizes[i] == size
+	}
+	return size >= bp.baseSize && size <= bp.maxSize && isPowerOfTwo(size)
+}
+
+// reject is called when the origin bytes can't be returned to the pool.
+func (bp *BytesPool) reject(origin []byte) {
+	atomic.AddInt64(&bp.freeRejections, 1)
+	if bp.opts.freeHook != nil {
+		bp.opts.freeHook(origin, -1)
+	}
+}
+
+// release stops tracking and accounting the origin bytes which are going to be put back to the i-th bucket.
+func (bp *BytesPool) release(origin []byte, i int) {
+	if bp.tracker != nil && !bp.tracker.forget(origin) && bp.opts.doubleFreeCheck {
+		panicNotAllocated(origin)
+	}
+	if bp.safetyNet != nil {
+		bp.disarm(origin)
+	}
+	if i < bp.numBuckets {
+		atomic.AddInt64(&bp.counters[i].live, -1)
+	}
+	if bp.opts.budget > 0 {
+		atomic.AddInt64(&bp.liveBytes, -int64(len(origin)))
+		bp.budgetWaiters.broadcast()
+	}
+	if bp.opts.freeHook != nil {
+		bp.opts.freeHook(origin, i)
+	}
+	if bp.opts.poisonOnFree {
+		poison(origin)
+	}
+}
+
+// panicNotAllocated is called by WithDoubleFreeCheck when origin is not an outstanding allocation.
+func panicNotAllocated(origin []byte) {
+	panic(fmt.Sprintf("bytespool: free %d bytes at %#x which are already freed or not allocated by the pool",
+		len(origin), bytesPointer(origin)))
+}
+
+// poisonPattern is used to fill the freed bytes if WithPoisonOnFree is used.
+var poisonPattern = [2]byte{0xDE, 0xAD}
+
+func poison(b []byte) {
+	if len(b) == 0 {
+		return
+	}
+	b[0] = poisonPattern[0]
+	if len(b) > 1 {
+		b[1] = poisonPattern[1]
+	}
+	// Double the poisoned prefix by copy.
+	for n := 2; n < len(b); n *= 2 {
+		copy(b[n:], b[:n])
+	}
 }
 
 func isPowerOfTwo(x int) bool {
-	return x&(x-1) == 0
+	return x > 0 && x&(x-1) == 0
 }
 
-func bucketIdx(size int) (i int) {
-	for size > baseSize {
-		size = (size + 1) >> 1
-		i++
+// allocIdx returns the index of the bucket Alloc uses for size, which is rounded up by WithRoundUp first.
+func (bp *BytesPool) allocIdx(size int) int {
+	g := bp.opts.roundUp
+	if g <= 0 {
+		return bp.bucketIdx(size)
 	}
-	return
+	if g >= bp.maxSize {
+		return bp.numBuckets - 1
+	}
+	if r := size % g; r != 0 {
+		size += g - r
+	}
+	if size > bp.maxSize {
+		size = bp.maxSize
+	}
+	return bp.bucketIdx(size)
+}
+
+// bucketIdx returns the index of the smallest bucket which can hold size bytes.
+// Sizes in (baseSize<<(i-1), baseSize<<i] map to bucket i, and sizes not larger than baseSize map to bucket 0,
+// e.g. with the default 1KB base size, 1024 maps to bucket 0, 1025 and 2048 map to bucket 1, 2049 maps to bucket 2.
+// The caller should make sure size is not larger than maxSize.
+func (bp *BytesPool) bucketIdx(size int) int {
+	if size <= bp.baseSize {
+		return 0
+	}
+	if bp.bucketSizes != nil {
+		return sort.SearchInts(bp.bucketSizes, size)
+	}
+	return bits.Len(uint(size-1)) - bp.baseShift
+}
+
+// bucketSize returns the size of the i-th bucket.
+func (bp *BytesPool) bucketSize(i int) int {
+	if bp.bucketSizes != nil {
+		return bp.bucketSizes[i]
+	}
+	return bp.baseSize << uint(i)
 }
+
+// geometricSizes returns the bucket sizes from baseSize to maxSize growing by factor,
+// every size is rounded up to a multiple of sizeAlign to keep the bytes aligned.
+func geometricSizes(baseSize, maxSize int, factor float64) []int {
+	sizes := []int{baseSize}
+	for size := baseSize; size < maxSize; {
+		next := int(math.Ceil(float64(size) * factor))
+		if r := next % sizeAlign; r != 0 {
+			next += sizeAlign - r
+		}
+		if next <= size {
+			next = size + sizeAlign
+		}
+		if next > maxSize {
+			next = maxSize
+		}
+		sizes = append(sizes, next)
+		size = next
+	}
+	return sizes
+}
+
+// sizeAlign is the alignment of the bucket sizes of WithGrowthFactor.
+const sizeAlign = 64
@@ -14,7 +14,9 @@
 package bytespool
 
 import (
+	"strconv"
 	"testing"
+	"unsafe"
 
 	. "github.com/pingcap/check"
 )
@@ -50,3 +52,602 @@ func (s *testBytesPoolSuite) TestBytesPool(c *C) {
 	c.Assert(bp.Free(make([]byte, 100)), Equals, -1)
 	c.Assert(bp.Free(make([]byte, kilo+1)), Equals, -1)
 }
+
+func (s *testBytesPoolSuite) TestBytesPoolWithConfig(c *C) {
+	bp, err := NewBytesPoolWithConfig(64, 4*kilo)
+	c.Assert(err, IsNil)
+	c.Assert(bp.numBuckets, Equals, 7)
+	poolTests := []struct {
+		size      int
+		allocSize int
+		freeIdx   int
+	}{
+		{10, 64, 0},
+		{64, 64, 0},
+		{65, 128, 1},
+		{kilo, kilo, 4},
+		{4 * kilo, 4 * kilo, 6},
+	}
+	for _, tt := range poolTests {
+		origin, data := bp.Alloc(tt.size)
+		c.Assert(len(data), Equals, tt.size)
+		c.Assert(len(origin), Equals, tt.allocSize)
+		c.Assert(bp.Free(origin), Equals, tt.freeIdx)
+	}
+	origin, data := bp.Alloc(4*kilo + 1)
+	c.Assert(origin, IsNil)
+	c.Assert(len(data), Equals, 4*kilo+1)
+	c.Assert(bp.Free(make([]byte, 32)), Equals, -1)
+	c.Assert(bp.Free(make([]byte, 8*kilo)), Equals, -1)
+
+	bp, err = NewBytesPoolWithConfig(kilo, kilo)
+	c.Assert(err, IsNil)
+	c.Assert(bp.numBuckets, Equals, 1)
+
+	invalidConfigs := [][2]int{
+		{0, kilo},
+		{-kilo, kilo},
+		{1000, 4 * kilo},
+		{kilo, 1000 * kilo},
+		{2 * kilo, kilo},
+	}
+	for _, cfg := range invalidConfigs {
+		_, err = NewBytesPoolWithConfig(cfg[0], cfg[1])
+		c.Assert(err, NotNil)
+	}
+}
+
+func (s *testBytesPoolSuite) TestAllocZeroed(c *C) {
+	bp := NewBytesPool()
+	origin, data := bp.Alloc(2 * kilo)
+	for i := range origin {
+		origin[i] = 0xff
+	}
+	bp.Free(origin)
+	for _, size := range []int{100, 2 * kilo, 2*kilo - 1, 129 * mega} {
+		origin, data = bp.AllocZeroed(size)
+		c.Assert(len(data), Equals, size)
+		for _, b := range data {
+			if b != 0 {
+				c.Fatalf("size %d is not zeroed", size)
+			}
+		}
+		bp.Free(origin)
+	}
+}
+
+func (s *testBytesPoolSuite) TestRealloc(c *C) {
+	bp, err := NewBytesPoolWithConfig(kilo, 4*kilo)
+	c.Assert(err, IsNil)
+	origin, data := bp.Alloc(10)
+	copy(data, "0123456789")
+
+	// Fits in the same bucket.
+	newOrigin, newData := bp.Realloc(origin, data, kilo)
+	c.Assert(&newOrigin[0], Equals, &origin[0])
+	c.Assert(len(newData), Equals, kilo)
+	c.Assert(string(newData[:10]), Equals, "0123456789")
+
+	// Promoted to a larger bucket.
+	origin, data = newOrigin, newData[:10]
+	newOrigin, newData = bp.Realloc(origin, data, 3*kilo)
+	c.Assert(len(newOrigin), Equals, 4*kilo)
+	c.Assert(len(newData), Equals, 3*kilo)
+	c.Assert(string(newData[:10]), Equals, "0123456789")
+	c.Assert(bp.Stats().Buckets[0].Frees, Equals, int64(1))
+
+	// Promoted to the oversized path.
+	origin, data = newOrigin, newData
+	newOrigin, newData = bp.Realloc(origin, data, 5*kilo)
+	c.Assert(newOrigin, IsNil)
+	c.Assert(len(newData), Equals, 5*kilo)
+	c.Assert(string(newData[:10]), Equals, "0123456789")
+	c.Assert(bp.Stats().Buckets[2].Frees, Equals, int64(1))
+
+	// Oversized data is resliced when it has enough capacity.
+	data = newData[:10]
+	newOrigin, newData = bp.Realloc(nil, data, 5*kilo)
+	c.Assert(newOrigin, IsNil)
+	c.Assert(&newData[0], Equals, &data[0])
+}
+
+func (s *testBytesPoolSuite) TestReturn(c *C) {
+	bp := NewBytesPool()
+	origin, _ := bp.Alloc(3 * kilo)
+	c.Assert(bp.Return(origin), IsTrue)
+	c.Assert(bp.Return(nil), IsFalse)
+	c.Assert(bp.Return(make([]byte, 100)), IsFalse)
+	c.Assert(bp.Return(make([]byte, 3*kilo)), IsFalse)
+	c.Assert(bp.Return(make([]byte, 256*mega)), IsFalse)
+	st := bp.Stats()
+	c.Assert(st.Frees, Equals, int64(1))
+	// Return(nil) is not counted.
+	c.Assert(st.FreeRejections, Equals, int64(3))
+}
+
+func (s *testBytesPoolSuite) TestClone(c *C) {
+	bp, err := NewBytesPoolWithConfig(kilo, 4*kilo, WithBudget(mega), WithLeakTracking(), WithName("parent"))
+	c.Assert(err, IsNil)
+	origin, _ := bp.Alloc(kilo)
+	bp.Free(origin)
+	bp.Alloc(2 * kilo)
+
+	clone := bp.Clone()
+	c.Assert(clone.Name(), Equals, "parent")
+	c.Assert(clone.BucketSizes(), DeepEquals, bp.BucketSizes())
+	c.Assert(clone.Stats().Gets, Equals, int64(0))
+	c.Assert(clone.LiveBytes(), Equals, int64(0))
+	c.Assert(clone.OutstandingAllocations(), HasLen, 0)
+	_, _, ok := clone.TryAlloc(2 * mega)
+	c.Assert(ok, IsFalse)
+	origin, _ = clone.Alloc(kilo)
+	c.Assert(clone.OutstandingAllocations(), HasLen, 1)
+	c.Assert(bp.OutstandingAllocations(), HasLen, 1)
+	c.Assert(bp.Stats().Gets, Equals, int64(2))
+	c.Assert(clone.Free(origin), Equals, 0)
+}
+
+func (s *testBytesPoolSuite) TestSetDefaultPool(c *C) {
+	old := DefaultPool
+	defer SetDefaultPool(old)
+	c.Assert(Default(), Equals, old)
+	bp, err := NewBytesPoolWithBudget(mega)
+	c.Assert(err, IsNil)
+	SetDefaultPool(bp)
+	c.Assert(Default(), Equals, bp)
+	c.Assert(DefaultPool, Equals, bp)
+}
+
+func (s *testBytesPoolSuite) TestReuse(c *C) {
+	bp, err := NewBytesPoolWithConfig(kilo, 4*kilo, WithLeakTracking())
+	c.Assert(err, IsNil)
+	origin, data := bp.Reuse(nil, 100)
+	c.Assert(len(origin), Equals, kilo)
+	c.Assert(len(data), Equals, 100)
+	newOrigin, data := bp.Reuse(origin, kilo)
+	c.Assert(&newOrigin[0], Equals, &origin[0])
+	c.Assert(len(data), Equals, kilo)
+	newOrigin, data = bp.Reuse(newOrigin, 3*kilo)
+	c.Assert(len(newOrigin), Equals, 4*kilo)
+	c.Assert(len(data), Equals, 3*kilo)
+	c.Assert(bp.OutstandingAllocations(), HasLen, 1)
+	newOrigin, data = bp.Reuse(newOrigin, 5*kilo)
+	c.Assert(newOrigin, IsNil)
+	c.Assert(len(data), Equals, 5*kilo)
+	c.Assert(bp.OutstandingAllocations(), HasLen, 0)
+	c.Assert(func() { bp.Reuse(origin, -1) }, PanicMatches, "bytespool: negative size")
+}
+
+func (s *testBytesPoolSuite) TestFreeWithCap(c *C) {
+	bp, err := NewBytesPoolWithConfig(kilo, 4*kilo, WithDoubleFreeCheck())
+	c.Assert(err, IsNil)
+	origin, _ := bp.Alloc(3 * kilo)
+	c.Assert(bp.FreeWithCap(origin[:10]), Equals, 2)
+	origin, _ = bp.Alloc(kilo)
+	c.Assert(bp.FreeWithCap(origin[:0]), Equals, 0)
+	c.Assert(bp.FreeWithCap(make([]byte, 10, 3*kilo)), Equals, -1)
+	c.Assert(bp.FreeWithCap(nil), Equals, -1)
+}
+
+func (s *testBytesPoolSuite) TestReslicedFreeCheck(c *C) {
+	bp, err := NewBytesPoolWithConfig(kilo, 8*kilo)
+	c.Assert(err, IsNil)
+	origin, _ := bp.Alloc(5000)
+	origin = origin[:100]
+	c.Assert(bp.Free(origin), Equals, -1)
+	c.Assert(bp.Stats().FreeRejections, Equals, int64(1))
+	origin, _ = bp.Alloc(5000)
+	origin = origin[:100]
+	c.Assert(bp.FreeWithCap(origin), Equals, 3)
+
+	bp, err = NewBytesPoolWithConfig(kilo, 8*kilo, WithReslicedFreeCheck())
+	c.Assert(err, IsNil)
+	origin, _ = bp.Alloc(5000)
+	c.Assert(func() { bp.Free(origin[:100]) }, PanicMatches, "bytespool: free 100 bytes at .* resliced from a 8192 bytes bucket, use FreeWithCap instead")
+	c.Assert(func() { bp.Free(origin[:kilo]) }, PanicMatches, "bytespool: free 1024 bytes .*")
+	c.Assert(bp.FreeWithCap(origin[:100]), Equals, 3)
+	// The bytes whose cap is not a bucket size are rejected as before.
+	c.Assert(bp.Free(make([]byte, 100, 3*kilo)), Equals, -1)
+	c.Assert(bp.Free(make([]byte, 100)), Equals, -1)
+}
+
+func (s *testBytesPoolSuite) TestIsPowerOfTwo(c *C) {
+	for _, x := range []int{0, -1, -2, -kilo, 3, kilo + 1, maxInt, -maxInt - 1} {
+		c.Assert(isPowerOfTwo(x), IsFalse, Commentf("%d", x))
+	}
+	for _, x := range []int{1, 2, kilo, defaultMaxSize, maxInt/2 + 1} {
+		c.Assert(isPowerOfTwo(x), IsTrue, Commentf("%d", x))
+	}
+}
+
+func (s *testBytesPoolSuite) TestBucketIdx(c *C) {
+	bp, err := NewBytesPoolWithConfig(64, 4*kilo)
+	c.Assert(err, IsNil)
+	bucketTests := []struct {
+		size int
+		idx  int
+	}{
+		{0, 0},
+		{1, 0},
+		{63, 0},
+		{64, 0},
+		{65, 1},
+		{127, 1},
+		{128, 1},
+		{129, 2},
+		{256, 2},
+		{257, 3},
+		{512, 3},
+		{513, 4},
+		{kilo, 4},
+		{kilo + 1, 5},
+		{2 * kilo, 5},
+		{2*kilo + 1, 6},
+		{4*kilo - 1, 6},
+		{4 * kilo, 6},
+	}
+	for _, tt := range bucketTests {
+		c.Assert(bp.bucketIdx(tt.size), Equals, tt.idx, Commentf("size %d", tt.size))
+	}
+
+	// Every boundary from baseSize-1 to maxSize+1 of the default pool.
+	bp = NewBytesPool()
+	c.Assert(bp.bucketIdx(defaultBaseSize-1), Equals, 0)
+	for i := 0; i < bp.numBuckets; i++ {
+		size := defaultBaseSize << uint(i)
+		c.Assert(bp.bucketIdx(size), Equals, i, Commentf("size %d", size))
+		if i+1 < bp.numBuckets {
+			c.Assert(bp.bucketIdx(size+1), Equals, i+1, Commentf("size %d", size+1))
+		}
+		if i > 0 {
+			c.Assert(bp.bucketIdx(size-1), Equals, i, Commentf("size %d", size-1))
+		}
+	}
+	origin, data := bp.Alloc(defaultMaxSize + 1)
+	c.Assert(origin, IsNil)
+	c.Assert(len(data), Equals, defaultMaxSize+1)
+}
+
+func (s *testBytesPoolSuite) TestAllocAligned(c *C) {
+	bp, err := NewBytesPoolWithConfig(kilo, 16*kilo)
+	c.Assert(err, IsNil)
+	for _, alignment := range []int{1, 8, 64, 4 * kilo} {
+		for _, size := range []int{1, 100, 4 * kilo, 16 * kilo} {
+			origin, data := bp.AllocAligned(size, alignment)
+			c.Assert(len(data), Equals, size)
+			buf := data[:cap(data)]
+			c.Assert(uintptr(unsafe.Pointer(&buf[0]))%uintptr(alignment), Equals, uintptr(0))
+			if origin != nil {
+				c.Assert(bp.Return(origin), IsTrue)
+			}
+		}
+	}
+	c.Assert(func() { bp.AllocAligned(kilo, 0) }, PanicMatches, "bytespool: alignment 0 is not a power of two")
+	c.Assert(func() { bp.AllocAligned(kilo, 48) }, PanicMatches, "bytespool: alignment 48 is not a power of two")
+}
+
+func (s *testBytesPoolSuite) TestHooks(c *C) {
+	var allocs, frees [][2]int
+	bp, err := NewBytesPoolWithConfig(kilo, 4*kilo,
+		WithAllocHook(func(size, bucket int) {
+			allocs = append(allocs, [2]int{size, bucket})
+		}),
+		WithFreeHook(func(origin []byte, bucket int) {
+			frees = append(frees, [2]int{len(origin), bucket})
+		}))
+	c.Assert(err, IsNil)
+	origin, _ := bp.Alloc(100)
+	bp.Free(origin)
+	origin, _ = bp.Alloc(3 * kilo)
+	bp.Free(origin)
+	bp.Alloc(5 * kilo)
+	bp.Free(make([]byte, 100))
+	c.Assert(allocs, DeepEquals, [][2]int{{100, 0}, {3 * kilo, 2}, {5 * kilo, -1}})
+	c.Assert(frees, DeepEquals, [][2]int{{kilo, 0}, {4 * kilo, 2}, {100, -1}})
+}
+
+func (s *testBytesPoolSuite) TestAllocator(c *C) {
+	var sizes []int
+	bp, err := NewBytesPoolWithConfig(kilo, 4*kilo, WithLargeObjectPool(4*kilo),
+		WithAllocator(func(size int) []byte {
+			sizes = append(sizes, size)
+			b := make([]byte, size)
+			for i := range b {
+				b[i] = 0xcc
+			}
+			return b
+		}))
+	c.Assert(err, IsNil)
+	_, data := bp.Alloc(100)
+	c.Assert(data[0], Equals, byte(0xcc))
+	bp.Alloc(3 * kilo)
+	bp.Alloc(5 * kilo)
+	bp.Prefill(2*kilo, 1)
+	c.Assert(sizes, DeepEquals, []int{kilo, 4 * kilo, 8 * kilo, 2 * kilo})
+}
+
+func (s *testBytesPoolSuite) TestBucketSizes(c *C) {
+	bp, err := NewBytesPoolWithConfig(kilo, 8*kilo)
+	c.Assert(err, IsNil)
+	c.Assert(bp.BucketSizes(), DeepEquals, []int{kilo, 2 * kilo, 4 * kilo, 8 * kilo})
+	sizes := NewBytesPool().BucketSizes()
+	c.Assert(sizes, HasLen, defaultNumBuckets)
+	c.Assert(sizes[len(sizes)-1], Equals, defaultMaxSize)
+}
+
+func (s *testBytesPoolSuite) TestBucketSizeSet(c *C) {
+	bp, err := NewBytesPoolWithConfig(kilo, 8*kilo)
+	c.Assert(err, IsNil)
+	set := bp.BucketSizeSet()
+	c.Assert(set, HasLen, 4)
+	for _, size := range bp.BucketSizes() {
+		_, ok := set[size]
+		c.Assert(ok, IsTrue)
+	}
+	_, ok := set[kilo/2]
+	c.Assert(ok, IsFalse)
+}
+
+func (s *testBytesPoolSuite) TestFreeIntoAnotherPool(c *C) {
+	a, err := NewBytesPoolWithConfig(512, 16*kilo)
+	c.Assert(err, IsNil)
+	b, err := NewBytesPoolWithConfig(2*kilo, 8*kilo)
+	c.Assert(err, IsNil)
+	set := b.BucketSizeSet()
+	for _, size := range a.BucketSizes() {
+		origin, _ := a.Alloc(size)
+		_, ok := set[size]
+		c.Assert(b.Return(origin), Equals, ok, Commentf("size %d", size))
+	}
+	c.Assert(b.Stats().FreeRejections, Equals, int64(3))
+}
+
+func (s *testBytesPoolSuite) TestGrowthFactor(c *C) {
+	for _, f := range []float64{-1, 0.5, 1, 2.5} {
+		_, err := NewBytesPoolWithOptions(WithGrowthFactor(f))
+		c.Assert(err, NotNil, Commentf("factor %v", f))
+	}
+
+	bp, err := NewBytesPoolWithConfig(4*kilo, 16*kilo, WithGrowthFactor(1.25), WithLeakTracking())
+	c.Assert(err, IsNil)
+	sizes := []int{4096, 5120, 6400, 8000, 10048, 12608, 15808, 16384}
+	c.Assert(bp.BucketSizes(), DeepEquals, sizes)
+	c.Assert(bp.Capacity(5*kilo), Equals, 5*kilo)
+	c.Assert(bp.Capacity(5*kilo+1), Equals, 6400)
+	c.Assert(bp.RoundSize(9000), Equals, 10048)
+	for i, size := range sizes {
+		c.Assert(bp.bucketIdx(size), Equals, i)
+		c.Assert(bp.bucketIdx(size-1), Equals, i)
+		origin, data := bp.Alloc(size - 1)
+		c.Assert(origin, HasLen, size)
+		c.Assert(data, HasLen, size-1)
+		c.Assert(bp.Free(origin), Equals, i)
+	}
+	origin, _ := bp.Alloc4K()
+	c.Assert(bp.Free(origin), Equals, 0)
+	c.Assert(bp.Free(make([]byte, 8*kilo)), Equals, -1)
+	c.Assert(bp.Free(make([]byte, 16*kilo+1)), Equals, -1)
+	c.Assert(bp.Stats().Buckets[3].Size, Equals, 8000)
+	c.Assert(bp.OutstandingAllocations(), HasLen, 0)
+
+	clone := bp.Clone()
+	c.Assert(clone.BucketSizes(), DeepEquals, sizes)
+	clone, err = NewBytesPoolWithConfig(4*kilo, 16*kilo, WithGrowthFactor(2))
+	c.Assert(err, IsNil)
+	c.Assert(clone.BucketSizes(), DeepEquals, []int{4 * kilo, 8 * kilo, 16 * kilo})
+}
+
+func (s *testBytesPoolSuite) TestHeapBelow(c *C) {
+	_, err := NewBytesPoolWithOptions(WithHeapBelow(-1))
+	c.Assert(err, NotNil)
+
+	bp, err := NewBytesPoolWithOptions(WithHeapBelow(256), WithBudget(mega))
+	c.Assert(err, IsNil)
+	origin, data := bp.Alloc(255)
+	c.Assert(origin, IsNil)
+	c.Assert(data, HasLen, 255)
+	c.Assert(bp.Capacity(255), Equals, 255)
+	c.Assert(bp.Free(origin), Equals, -1)
+	origin, data = bp.Alloc(256)
+	c.Assert(len(origin), Equals, kilo)
+	c.Assert(bp.Free(origin), Equals, 0)
+
+	_, data, ok := bp.TryAlloc(100)
+	c.Assert(ok, IsTrue)
+	c.Assert(data, HasLen, 100)
+	origins, datas := bp.AllocMany([]int{100, 300})
+	c.Assert(origins[0], IsNil)
+	c.Assert(datas[0], HasLen, 100)
+	c.Assert(len(origins[1]), Equals, kilo)
+	c.Assert(bp.FreeMany(origins), Equals, 1)
+	c.Assert(bp.LiveBytes(), Equals, int64(0))
+
+	st := bp.Stats()
+	c.Assert(st.Gets, Equals, int64(2))
+	c.Assert(st.FreeRejections, Equals, int64(0))
+}
+
+func (s *testBytesPoolSuite) TestTryAllocPooled(c *C) {
+	bp, err := NewBytesPoolWithConfig(kilo, 4*kilo, WithHeapBelow(100))
+	c.Assert(err, IsNil)
+	for _, t := range []struct {
+		size   int
+		pooled bool
+	}{{0, false}, {10, false}, {100, true}, {4 * kilo, true}, {4*kilo + 1, false}} {
+		origin, data, pooled := bp.TryAllocPooled(t.size)
+		c.Assert(data, HasLen, t.size)
+		c.Assert(pooled, Equals, t.pooled, Commentf("size %d", t.size))
+		c.Assert(bp.Return(origin), Equals, t.pooled)
+	}
+
+	bp, err = NewBytesPoolWithConfig(kilo, 4*kilo, WithLargeObjectPool(kilo))
+	c.Assert(err, IsNil)
+	origin, _, pooled := bp.TryAllocPooled(5 * kilo)
+	c.Assert(pooled, IsTrue)
+	c.Assert(bp.Return(origin), IsTrue)
+
+	if mmapSupported {
+		bp, err = NewBytesPoolWithConfig(kilo, 4*kilo, WithDiskSpill(4*kilo, ""))
+		c.Assert(err, IsNil)
+		origin, _, pooled := bp.TryAllocPooled(5 * kilo)
+		c.Assert(origin, NotNil)
+		c.Assert(pooled, IsFalse)
+		c.Assert(bp.Return(origin), IsFalse)
+	}
+}
+
+func (s *testBytesPoolSuite) TestAllocForWrite(c *C) {
+	bp, err := NewBytesPoolWithConfig(kilo, 4*kilo)
+	c.Assert(err, IsNil)
+	origin, buf := bp.AllocForWrite(3 * kilo)
+	c.Assert(len(buf), Equals, 4*kilo)
+	c.Assert(&buf[0], Equals, &origin[0])
+	c.Assert(bp.Free(origin), Equals, 2)
+	origin, buf = bp.AllocForWrite(5 * kilo)
+	c.Assert(origin, IsNil)
+	c.Assert(len(buf), Equals, 5*kilo)
+	origin, buf = bp.AllocForWrite(0)
+	c.Assert(origin, IsNil)
+	c.Assert(buf, HasLen, 0)
+}
+
+func (s *testBytesPoolSuite) TestAllocAppendable(c *C) {
+	bp, err := NewBytesPoolWithConfig(kilo, 4*kilo, WithLargeObjectPool(kilo))
+	c.Assert(err, IsNil)
+	for _, size := range []int{1, kilo, 3 * kilo, 5 * kilo} {
+		origin, data := bp.AllocAppendable(size)
+		c.Assert(data, HasLen, size)
+		c.Assert(cap(data), Equals, bp.Capacity(size))
+		data = append(data, make([]byte, cap(data)-size)...)
+		c.Assert(&data[0], Equals, &origin[0])
+		data = append(data, 'x')
+		c.Assert(&data[0] != &origin[0], IsTrue)
+		c.Assert(bp.Return(origin), IsTrue)
+	}
+	origin, data := bp.AllocAppendable(0)
+	c.Assert(origin, IsNil)
+	c.Assert(data, HasLen, 0)
+}
+
+func (s *testBytesPoolSuite) TestCapacity(c *C) {
+	bp, err := NewBytesPoolWithConfig(kilo, 4*kilo)
+	c.Assert(err, IsNil)
+	for _, size := range []int{0, 1, kilo, kilo + 1, 3 * kilo, 4 * kilo, 4*kilo + 1, 100 * kilo} {
+		origin, data := bp.Alloc(size)
+		if origin == nil {
+			c.Assert(bp.Capacity(size), Equals, len(data))
+		} else {
+			c.Assert(bp.Capacity(size), Equals, len(origin))
+		}
+	}
+	c.Assert(bp.Capacity(4*kilo+1), Equals, 4*kilo+1)
+
+	bp, err = NewBytesPoolWithConfig(kilo, 4*kilo, WithLargeObjectPool(4*kilo))
+	c.Assert(err, IsNil)
+	c.Assert(bp.Capacity(4*kilo+1), Equals, 8*kilo)
+}
+
+func (s *testBytesPoolSuite) TestRoundSize(c *C) {
+	bp, err := NewBytesPoolWithConfig(kilo, 64*kilo, WithHeapBelow(100))
+	c.Assert(err, IsNil)
+	c.Assert(bp.RoundSize(-1), Equals, 0)
+	c.Assert(bp.IsPooled(-1), IsFalse)
+	c.Assert(bp.RoundSize(0), Equals, 0)
+	c.Assert(bp.IsPooled(0), IsFalse)
+	c.Assert(bp.RoundSize(1), Equals, kilo)
+	for bucket := kilo; bucket <= 64*kilo; bucket *= 2 {
+		c.Assert(bp.RoundSize(bucket-1), Equals, bucket)
+		c.Assert(bp.RoundSize(bucket), Equals, bucket)
+		c.Assert(bp.IsPooled(bucket), IsTrue)
+		if bucket < 64*kilo {
+			c.Assert(bp.RoundSize(bucket+1), Equals, 2*bucket)
+		}
+	}
+	c.Assert(bp.RoundSize(64*kilo+1), Equals, 64*kilo+1)
+	c.Assert(bp.IsPooled(64*kilo+1), IsFalse)
+}
+
+func (s *testBytesPoolSuite) TestRoundUp(c *C) {
+	_, err := NewBytesPoolWithOptions(WithRoundUp(-1))
+	c.Assert(err, NotNil)
+
+	bp, err := NewBytesPoolWithConfig(kilo, 64*kilo, WithRoundUp(8*kilo), WithBudget(mega))
+	c.Assert(err, IsNil)
+	for _, t := range []struct {
+		size   int
+		bucket int
+	}{{1, 8 * kilo}, {3 * kilo, 8 * kilo}, {5 * kilo, 8 * kilo}, {8*kilo + 1, 16 * kilo}, {40 * kilo, 64 * kilo}} {
+		c.Assert(bp.Capacity(t.size), Equals, t.bucket)
+		c.Assert(bp.RoundSize(t.size), Equals, t.bucket)
+		origin, data := bp.Alloc(t.size)
+		c.Assert(origin, HasLen, t.bucket)
+		c.Assert(data, HasLen, t.size)
+		c.Assert(bp.Return(origin), IsTrue)
+		origin, _, ok := bp.TryAlloc(t.size)
+		c.Assert(ok, IsTrue)
+		c.Assert(origin, HasLen, t.bucket)
+		c.Assert(bp.Return(origin), IsTrue)
+	}
+	origin, _ := bp.Alloc1K()
+	c.Assert(origin, HasLen, 8*kilo)
+	c.Assert(bp.Return(origin), IsTrue)
+	origins, _ := bp.AllocMany([]int{3 * kilo, 5 * kilo})
+	c.Assert(origins[0], HasLen, 8*kilo)
+	c.Assert(origins[1], HasLen, 8*kilo)
+	c.Assert(bp.FreeMany(origins), Equals, 2)
+	c.Assert(bp.LiveBytes(), Equals, int64(0))
+
+	// The rounded sizes are capped to the max size.
+	bp, err = NewBytesPoolWithConfig(kilo, 4*kilo, WithRoundUp(3*kilo))
+	c.Assert(err, IsNil)
+	c.Assert(bp.Capacity(kilo), Equals, 4*kilo)
+	c.Assert(bp.Capacity(4*kilo), Equals, 4*kilo)
+	bp, err = NewBytesPoolWithConfig(kilo, 4*kilo, WithRoundUp(mega))
+	c.Assert(err, IsNil)
+	c.Assert(bp.Capacity(1), Equals, 4*kilo)
+}
+
+func (s *testBytesPoolSuite) TestZeroAndNegativeSize(c *C) {
+	bp := NewBytesPool()
+	origin, data := bp.Alloc(0)
+	c.Assert(origin, IsNil)
+	c.Assert(data, HasLen, 0)
+	c.Assert(bp.Stats().Gets, Equals, int64(0))
+	c.Assert(bp.Free(origin), Equals, -1)
+	origin, data = bp.AllocZeroed(0)
+	c.Assert(origin, IsNil)
+	c.Assert(data, HasLen, 0)
+	origin, data = bp.AllocAligned(0, 64)
+	c.Assert(origin, IsNil)
+	c.Assert(data, HasLen, 0)
+	c.Assert(bp.Capacity(0), Equals, 0)
+
+	c.Assert(func() { bp.Alloc(-1) }, PanicMatches, "bytespool: negative size")
+	c.Assert(func() { bp.AllocAligned(-1, 64) }, PanicMatches, "bytespool: negative size")
+	c.Assert(func() { bp.Capacity(-1) }, PanicMatches, "bytespool: negative size")
+	budgeted, err := NewBytesPoolWithBudget(kilo)
+	c.Assert(err, IsNil)
+	c.Assert(func() { budgeted.TryAlloc(-1) }, PanicMatches, "bytespool: negative size")
+	_, _, ok := budgeted.TryAlloc(0)
+	c.Assert(ok, IsTrue)
+}
+
+func (s *testBytesPoolSuite) TestMaxIntSize(c *C) {
+	bp := NewBytesPool()
+	c.Assert(bp.Capacity(maxInt), Equals, maxInt)
+	// The huge size reaches make through the oversized path instead of overflowing the bucket arithmetic.
+	// On 32-bit platforms make may succeed with the 2GB size, so only the 64-bit make is sure to panic.
+	if strconv.IntSize == 64 {
+		c.Assert(func() { bp.Alloc(maxInt) }, PanicMatches, ".*makeslice: len out of range")
+		c.Assert(bp.Stats().Oversized, Equals, int64(1))
+		c.Assert(bp.Stats().Gets, Equals, int64(0))
+	}
+
+	bp, err := NewBytesPoolWithOptions(WithLargeObjectPool(64*mega), WithBudget(kilo))
+	c.Assert(err, IsNil)
+	c.Assert(bp.Capacity(maxInt), Equals, maxInt)
+	c.Assert(bp.Capacity(maxInt-64*mega), Equals, maxInt-64*mega+1)
+	_, _, ok := bp.TryAlloc(maxInt)
+	c.Assert(ok, IsFalse)
+	c.Assert(bp.LiveBytes(), Equals, int64(0))
+}
@@ -0,0 +1,100 @@
+// Copyright 2017 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package bytespoolmetrics exports the statistics of a bytespool.BytesPool to Prometheus.
+// It is a separate package so bytespool doesn't depend on the Prometheus client.
+//
+// The per-bucket metrics have the labels "bucket" (the bucket index) and "size" (the byte size of the bucket):
+//
+//	tidb_bytespool_hits_total        counter, allocations which reused pooled bytes.
+//	tidb_bytespool_misses_total      counter, allocations which found the bucket empty.
+//	tidb_bytespool_frees_total       counter, bytes returned to the bucket.
+//	tidb_bytespool_live_bytes        gauge, bytes allocated from the bucket and not freed yet.
+//
+// All the metrics have the label "pool" if the pool is named by bytespool.WithName,
+// so the collectors of multiple named pools can be registered together.
+//
+// The pool-wide metrics have no other labels:
+//
+//	tidb_bytespool_free_rejections_total  counter, Free calls which did not return the bytes to the pool.
+//	tidb_bytespool_oversized_total        counter, allocations larger than the max size of the pool.
+package bytespoolmetrics
+
+import (
+	"strconv"
+
+	"github.com/pingcap/tidb/util/bytespool"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var bucketLabels = []string{"bucket", "size"}
+
+// Collector implements prometheus.Collector for a BytesPool.
+type Collector struct {
+	pool *bytespool.BytesPool
+
+	hits           *prometheus.Desc
+	misses         *prometheus.Desc
+	frees          *prometheus.Desc
+	liveBytes      *prometheus.Desc
+	freeRejections *prometheus.Desc
+	oversized      *prometheus.Desc
+}
+
+// NewCollector creates a Collector for pool, it should be registered to a prometheus.Registerer.
+func NewCollector(pool *bytespool.BytesPool) *Collector {
+	var constLabels prometheus.Labels
+	if name := pool.Name(); name != "" {
+		constLabels = prometheus.Labels{"pool": name}
+	}
+	return &Collector{
+		pool: pool,
+		hits: prometheus.NewDesc("tidb_bytespool_hits_total",
+			"Counter of allocations which reused pooled bytes.", bucketLabels, constLabels),
+		misses: prometheus.NewDesc("tidb_bytespool_misses_total",
+			"Counter of allocations which found the bucket empty and created new bytes.", bucketLabels, constLabels),
+		frees: prometheus.NewDesc("tidb_bytespool_frees_total",
+			"Counter of bytes returned to the bucket.", bucketLabels, constLabels),
+		liveBytes: prometheus.NewDesc("tidb_bytespool_live_bytes",
+			"Bytes allocated from the bucket and not freed yet.", bucketLabels, constLabels),
+		freeRejections: prometheus.NewDesc("tidb_bytespool_free_rejections_total",
+			"Counter of Free calls which did not return the bytes to the pool.", nil, constLabels),
+		oversized: prometheus.NewDesc("tidb_bytespool_oversized_total",
+			"Counter of allocations larger than the max size of the pool.", nil, constLabels),
+	}
+}
+
+// Describe implements prometheus.Collector interface.
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.hits
+	ch <- c.misses
+	ch <- c.frees
+	ch <- c.liveBytes
+	ch <- c.freeRejections
+	ch <- c.oversized
+}
+
+// Collect implements prometheus.Collector interface.
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	st := c.pool.Stats()
+	for i, b := range st.Buckets {
+		labels := []string{strconv.Itoa(i), strconv.Itoa(b.Size)}
+		ch <- prometheus.MustNewConstMetric(c.hits, prometheus.CounterValue, float64(b.Hits()), labels...)
+		ch <- prometheus.MustNewConstMetric(c.misses, prometheus.CounterValue, float64(b.Misses), labels...)
+		ch <- prometheus.MustNewConstMetric(c.frees, prometheus.CounterValue, float64(b.Frees), labels...)
+		live := float64(b.Live) * float64(b.Size)
+		ch <- prometheus.MustNewConstMetric(c.liveBytes, prometheus.GaugeValue, live, labels...)
+	}
+	ch <- prometheus.MustNewConstMetric(c.freeRejections, prometheus.CounterValue, float64(st.FreeRejections))
+	ch <- prometheus.MustNewConstMetric(c.oversized, prometheus.CounterValue, float64(st.Oversized))
+}
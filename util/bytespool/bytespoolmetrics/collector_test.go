@@ -0,0 +1,88 @@
+// Copyright 2017 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bytespoolmetrics
+
+import (
+	"testing"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/tidb/util/bytespool"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestT(t *testing.T) {
+	TestingT(t)
+}
+
+var _ = Suite(&testCollectorSuite{})
+
+type testCollectorSuite struct{}
+
+func (s *testCollectorSuite) TestCollector(c *C) {
+	pool, err := bytespool.NewBytesPoolWithConfig(1024, 4096)
+	c.Assert(err, IsNil)
+	origin, _ := pool.Alloc(100)
+	pool.Free(origin)
+	pool.Alloc(4000)
+	pool.Alloc(5000)
+
+	reg := prometheus.NewRegistry()
+	c.Assert(reg.Register(NewCollector(pool)), IsNil)
+	mfs, err := reg.Gather()
+	c.Assert(err, IsNil)
+	values := make(map[string]float64)
+	for _, mf := range mfs {
+		for _, m := range mf.GetMetric() {
+			key := mf.GetName()
+			for _, l := range m.GetLabel() {
+				key += "," + l.GetName() + "=" + l.GetValue()
+			}
+			if m.GetCounter() != nil {
+				values[key] = m.GetCounter().GetValue()
+			} else {
+				values[key] = m.GetGauge().GetValue()
+			}
+		}
+	}
+	c.Assert(values["tidb_bytespool_misses_total,bucket=2,size=4096"], Equals, float64(1))
+	c.Assert(values["tidb_bytespool_frees_total,bucket=0,size=1024"], Equals, float64(1))
+	c.Assert(values["tidb_bytespool_live_bytes,bucket=0,size=1024"], Equals, float64(0))
+	c.Assert(values["tidb_bytespool_live_bytes,bucket=2,size=4096"], Equals, float64(4096))
+	c.Assert(values["tidb_bytespool_oversized_total"], Equals, float64(1))
+	c.Assert(values["tidb_bytespool_free_rejections_total"], Equals, float64(0))
+	_, ok := values["tidb_bytespool_hits_total,bucket=1,size=2048"]
+	c.Assert(ok, IsTrue)
+}
+
+func (s *testCollectorSuite) TestNamedPools(c *C) {
+	reg := prometheus.NewRegistry()
+	for _, name := range []string{"network", "storage"} {
+		pool, err := bytespool.NewBytesPoolWithOptions(bytespool.WithName(name))
+		c.Assert(err, IsNil)
+		c.Assert(reg.Register(NewCollector(pool)), IsNil)
+	}
+	mfs, err := reg.Gather()
+	c.Assert(err, IsNil)
+	pools := make(map[string]bool)
+	for _, mf := range mfs {
+		for _, m := range mf.GetMetric() {
+			for _, l := range m.GetLabel() {
+				if l.GetName() == "pool" {
+					pools[l.GetValue()] = true
+				}
+			}
+		}
+	}
+	c.Assert(pools, DeepEquals, map[string]bool{"network": true, "storage": true})
+}
@@ -0,0 +1,54 @@
+// Copyright 2017 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bytespool
+
+import (
+	"sync/atomic"
+
+	"github.com/juju/errors"
+)
+
+// Close drops all the idle bytes and marks the pool closed, allocating from a closed pool panics
+// and prefilling it does nothing.
+// The bytes allocated before Close can still be freed, they are accounted but not pooled.
+// It stops the background trimmer of WithAutoTrim and the warmer of WithAdaptiveWarm, and removes the pool
+// registered by WithRegister, which are the reason to close a pool,
+// plain pools don't need to be closed.
+// It returns an error if the pool is closed already.
+func (bp *BytesPool) Close() error {
+	if !atomic.CompareAndSwapInt32(&bp.closed, 0, 1) {
+		return errors.New("bytespool: the pool is already closed")
+	}
+	if bp.trimmer != nil {
+		bp.trimmer.stop()
+	}
+	if bp.warmer != nil {
+		bp.warmer.stop()
+	}
+	if bp.opts.register {
+		bp.Unregister()
+	}
+	bp.Clear()
+	return nil
+}
+
+func (bp *BytesPool) isClosed() bool {
+	return atomic.LoadInt32(&bp.closed) != 0
+}
+
+func (bp *BytesPool) checkOpen() {
+	if bp.isClosed() {
+		panic("bytespool: alloc from a closed pool")
+	}
+}
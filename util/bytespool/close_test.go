@@ -0,0 +1,40 @@
+// Copyright 2017 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bytespool
+
+import (
+	. "github.com/pingcap/check"
+)
+
+func (s *testBytesPoolSuite) TestClose(c *C) {
+	bp, err := NewBytesPoolWithConfig(kilo, 4*kilo, WithBudget(mega), WithRetain(), WithLargeObjectPool(4*kilo))
+	c.Assert(err, IsNil)
+	origin, _ := bp.Alloc(kilo)
+	large, _ := bp.Alloc(5 * kilo)
+	bp.Prefill(2*kilo, 2)
+
+	c.Assert(bp.Close(), IsNil)
+	c.Assert(bp.Close(), NotNil)
+	c.Assert(bp.retained[1].len(), Equals, 0)
+	c.Assert(func() { bp.Alloc(kilo) }, PanicMatches, "bytespool: alloc from a closed pool")
+	c.Assert(func() { bp.Alloc(5 * kilo) }, PanicMatches, "bytespool: alloc from a closed pool")
+	c.Assert(func() { bp.TryAlloc(kilo) }, PanicMatches, "bytespool: alloc from a closed pool")
+	c.Assert(func() { bp.AllocMany([]int{kilo}) }, PanicMatches, "bytespool: alloc from a closed pool")
+
+	// The bytes allocated before Close are accounted but not pooled.
+	c.Assert(bp.Free(origin), Equals, -1)
+	c.Assert(bp.Free(large), Equals, -1)
+	c.Assert(bp.retained[0].len(), Equals, 0)
+	c.Assert(bp.LiveBytes(), Equals, int64(0))
+}
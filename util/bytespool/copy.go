@@ -0,0 +1,47 @@
+// Copyright 2017 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bytespool
+
+import (
+	"io"
+)
+
+const defaultCopyBufSize = 32 * kilo
+
+// Copy is like io.CopyBuffer, but the buffer of bufSize bytes is allocated from pool
+// and always freed before returning. If bufSize is 0, 32KB is used.
+func Copy(pool *BytesPool, dst io.Writer, src io.Reader, bufSize int) (int64, error) {
+	if bufSize <= 0 {
+		bufSize = defaultCopyBufSize
+	}
+	origin, buf := pool.Alloc(bufSize)
+	defer pool.Free(origin)
+	return io.CopyBuffer(dst, src, buf)
+}
+
+// Concat allocates the total length of parts from pool at once and copies the parts into it in order.
+// The caller should free origin after using data. As Alloc, origin is nil if the total length is 0
+// or larger than the max size of pool.
+func Concat(pool *BytesPool, parts ...[]byte) (origin, data []byte) {
+	n := 0
+	for _, part := range parts {
+		n += len(part)
+	}
+	origin, data = pool.Alloc(n)
+	off := 0
+	for _, part := range parts {
+		off += copy(data[off:], part)
+	}
+	return origin, data
+}
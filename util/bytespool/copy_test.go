@@ -0,0 +1,77 @@
+// Copyright 2017 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bytespool
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+
+	. "github.com/pingcap/check"
+)
+
+type errWriter struct{}
+
+func (errWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("write error")
+}
+
+// onlyReader hides the WriterTo of the underlying reader, so io.CopyBuffer uses the buffer.
+type onlyReader struct {
+	io.Reader
+}
+
+func (s *testBytesPoolSuite) TestCopy(c *C) {
+	bp, err := NewBytesPoolWithOptions(WithLeakTracking())
+	c.Assert(err, IsNil)
+	src := strings.Repeat("abcdefgh", 10000)
+	var dst bytes.Buffer
+	n, err := Copy(bp, &dst, onlyReader{strings.NewReader(src)}, 0)
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, int64(len(src)))
+	c.Assert(dst.String(), Equals, src)
+	c.Assert(bp.Stats().Buckets[bp.bucketIdx(defaultCopyBufSize)].Gets, Equals, int64(1))
+
+	dst.Reset()
+	n, err = Copy(bp, &dst, onlyReader{strings.NewReader(src)}, 100)
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, int64(len(src)))
+	c.Assert(dst.String(), Equals, src)
+
+	_, err = Copy(bp, errWriter{}, onlyReader{strings.NewReader(src)}, 0)
+	c.Assert(err, ErrorMatches, "write error")
+	c.Assert(bp.OutstandingAllocations(), HasLen, 0)
+}
+
+func (s *testBytesPoolSuite) TestConcat(c *C) {
+	bp, err := NewBytesPoolWithConfig(kilo, 4*kilo)
+	c.Assert(err, IsNil)
+	origin, data := Concat(bp)
+	c.Assert(origin, IsNil)
+	c.Assert(data, HasLen, 0)
+	origin, data = Concat(bp, nil, []byte{})
+	c.Assert(origin, IsNil)
+	c.Assert(data, HasLen, 0)
+
+	origin, data = Concat(bp, []byte("header,"), nil, []byte("body,"), []byte("footer"))
+	c.Assert(string(data), Equals, "header,body,footer")
+	c.Assert(bp.Free(origin), Equals, 0)
+
+	// Oversized.
+	big := bytes.Repeat([]byte{'a'}, 3*kilo)
+	origin, data = Concat(bp, big, big)
+	c.Assert(origin, IsNil)
+	c.Assert(data, DeepEquals, append(big, big...))
+}
@@ -0,0 +1,90 @@
+// Copyright 2017 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bytespool
+
+import (
+	"reflect"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// safetyNet arms a finalizer on the backing array of every outstanding origin if WithFinalizerSafetyNet is used,
+// the arrays are keyed by their pointers, which don't keep them alive.
+type safetyNet struct {
+	leaks int64
+
+	mu    sync.Mutex
+	armed map[uintptr]struct{}
+}
+
+func newSafetyNet() *safetyNet {
+	return &safetyNet{armed: make(map[uintptr]struct{})}
+}
+
+// arm sets the finalizer on the backing array of origin, which should be allocated on the heap.
+func (bp *BytesPool) arm(origin []byte) {
+	net := bp.safetyNet
+	net.mu.Lock()
+	net.armed[bytesPointer(origin)] = struct{}{}
+	net.mu.Unlock()
+	n := len(origin)
+	runtime.SetFinalizer(&origin[0], func(p *byte) {
+		bp.finalize(p, n)
+	})
+}
+
+// disarm clears the finalizer set by arm, it does nothing if origin is not armed.
+func (bp *BytesPool) disarm(origin []byte) {
+	net := bp.safetyNet
+	ptr := bytesPointer(origin)
+	net.mu.Lock()
+	_, ok := net.armed[ptr]
+	delete(net.armed, ptr)
+	net.mu.Unlock()
+	if ok {
+		runtime.SetFinalizer(&origin[0], nil)
+	}
+}
+
+// finalize is called when an armed origin of n bytes is garbage collected without being freed,
+// it logs the leak and returns the bytes to the pool.
+func (bp *BytesPool) finalize(p *byte, n int) {
+	var origin []byte
+	sh := (*reflect.SliceHeader)(unsafe.Pointer(&origin))
+	sh.Data, sh.Len, sh.Cap = uintptr(unsafe.Pointer(p)), n, n
+	atomic.AddInt64(&bp.safetyNet.leaks, 1)
+	var stack string
+	if bp.tracker != nil {
+		bp.tracker.Lock()
+		if a, ok := bp.tracker.allocs[bytesPointer(origin)]; ok {
+			stack = ", " + a.String()
+		}
+		bp.tracker.Unlock()
+	}
+	log.Warnf("bytespool: %d bytes are garbage collected without being freed, return them to the pool%s", n, stack)
+	bp.Return(origin)
+}
+
+// FinalizedLeaks returns the number of the origins freed by the finalizers of WithFinalizerSafetyNet,
+// every one of them is a missing Free. It is 0 if the safety net is not used.
+func (bp *BytesPool) FinalizedLeaks() int64 {
+	if bp.safetyNet == nil {
+		return 0
+	}
+	return atomic.LoadInt64(&bp.safetyNet.leaks)
+}
@@ -0,0 +1,61 @@
+// Copyright 2017 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bytespool
+
+import (
+	"runtime"
+	"time"
+
+	. "github.com/pingcap/check"
+)
+
+// waitFinalizedLeaks runs GC until the pool has n finalized leaks or it times out.
+func waitFinalizedLeaks(bp *BytesPool, n int64) {
+	for i := 0; i < 100 && bp.FinalizedLeaks() < n; i++ {
+		runtime.GC()
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func (s *testBytesPoolSuite) TestFinalizerSafetyNet(c *C) {
+	_, err := NewBytesPoolWithOptions(WithFinalizerSafetyNet(), WithAllocator(func(n int) []byte { return make([]byte, n) }))
+	c.Assert(err, NotNil)
+	c.Assert(NewBytesPool().FinalizedLeaks(), Equals, int64(0))
+
+	bp, err := NewBytesPoolWithConfig(kilo, 4*kilo, WithFinalizerSafetyNet(), WithLeakTracking(),
+		WithLargeObjectPool(kilo), WithRetain())
+	c.Assert(err, IsNil)
+	func() {
+		bp.Alloc(3 * kilo)
+		bp.Alloc(5 * kilo)
+	}()
+	waitFinalizedLeaks(bp, 2)
+	c.Assert(bp.FinalizedLeaks(), Equals, int64(2))
+	c.Assert(bp.OutstandingAllocations(), HasLen, 0)
+	c.Assert(bp.Stats().Buckets[2].Live, Equals, int64(0))
+	c.Assert(bp.retained[2].len(), Equals, 1)
+
+	// The bytes freed properly are not finalized, even if they are dropped by the pool later.
+	func() {
+		origin, _ := bp.Alloc(kilo)
+		bp.Free(origin)
+	}()
+	bp.TrimTo(0)
+	for i := 0; i < 5; i++ {
+		runtime.GC()
+		time.Sleep(time.Millisecond)
+	}
+	c.Assert(bp.FinalizedLeaks(), Equals, int64(2))
+	c.Assert(bp.safetyNet.armed, HasLen, 0)
+}
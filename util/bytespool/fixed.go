@@ -0,0 +1,44 @@
+// Copyright 2017 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bytespool
+
+// Alloc1K is like Alloc(1024), but it finds the bucket without bucketIdx, origin and data are the same bytes.
+func (bp *BytesPool) Alloc1K() (origin, data []byte) {
+	return bp.allocShift(10, 1)
+}
+
+// Alloc4K is like Alloc(4096), but it finds the bucket without bucketIdx, origin and data are the same bytes.
+func (bp *BytesPool) Alloc4K() (origin, data []byte) {
+	return bp.allocShift(12, 1)
+}
+
+// Alloc64K is like Alloc(65536), but it finds the bucket without bucketIdx, origin and data are the same bytes.
+func (bp *BytesPool) Alloc64K() (origin, data []byte) {
+	return bp.allocShift(16, 1)
+}
+
+// allocShift allocates 1<<shift bytes, it falls back to Alloc if the size doesn't map to a bucket directly
+// because of the configuration of the pool. skip is the number of its callers in the pool.
+func (bp *BytesPool) allocShift(shift uint, skip int) (origin, data []byte) {
+	size := 1 << shift
+	i := int(shift) - bp.baseShift
+	if i < 0 || i >= bp.numBuckets || size < bp.opts.heapBelow || bp.sizes != nil || bp.opts.roundUp > 0 || bp.bucketSizes != nil {
+		return bp.alloc(size, skip+1)
+	}
+	bp.checkOpen()
+	if bp.opts.budget > 0 {
+		bp.addLive(int64(size))
+	}
+	return bp.get(i, size, skip+1)
+}
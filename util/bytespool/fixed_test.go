@@ -0,0 +1,61 @@
+// Copyright 2017 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bytespool
+
+import (
+	"testing"
+
+	. "github.com/pingcap/check"
+)
+
+func (s *testBytesPoolSuite) TestAllocFixed(c *C) {
+	bp, err := NewBytesPoolWithOptions(WithBudget(mega))
+	c.Assert(err, IsNil)
+	for i, alloc := range []func() ([]byte, []byte){bp.Alloc1K, bp.Alloc4K, bp.Alloc64K} {
+		size := []int{kilo, 4 * kilo, 64 * kilo}[i]
+		origin, data := alloc()
+		c.Assert(origin, HasLen, size)
+		c.Assert(data, HasLen, size)
+		c.Assert(bp.LiveBytes(), Equals, int64(size))
+		c.Assert(bp.Free(origin), Equals, bp.bucketIdx(size))
+	}
+
+	// Falls back to Alloc if the sizes are out of the buckets.
+	bp, err = NewBytesPoolWithConfig(4*kilo, 8*kilo)
+	c.Assert(err, IsNil)
+	origin, data := bp.Alloc1K()
+	c.Assert(origin, HasLen, 4*kilo)
+	c.Assert(data, HasLen, kilo)
+	origin, _ = bp.Alloc4K()
+	c.Assert(bp.Free(origin), Equals, 0)
+	origin, data = bp.Alloc64K()
+	c.Assert(origin, IsNil)
+	c.Assert(data, HasLen, 64*kilo)
+}
+
+func BenchmarkAlloc4K(b *testing.B) {
+	bp := NewBytesPool()
+	for i := 0; i < b.N; i++ {
+		origin, _ := bp.Alloc(4 * kilo)
+		bp.Free(origin)
+	}
+}
+
+func BenchmarkAllocFixed4K(b *testing.B) {
+	bp := NewBytesPool()
+	for i := 0; i < b.N; i++ {
+		origin, _ := bp.Alloc4K()
+		bp.Free(origin)
+	}
+}
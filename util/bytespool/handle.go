@@ -0,0 +1,89 @@
+// Copyright 2017 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bytespool
+
+import (
+	"sync"
+)
+
+// Handle owns bytes allocated by AllocHandle, Free can be called by any of its holders
+// and only the first call frees the bytes, so the ownership can be handed over between goroutines
+// without the double free check. It is a small value which should be passed by value.
+type Handle struct {
+	s *handleState
+	// gen is the generation of s when the handle is created, s is reused once gen changes.
+	gen uint64
+}
+
+// handleState is pooled, so AllocHandle makes no garbage. Its generation is increased when it's freed,
+// so the stale handles of the previous owners can't free it again.
+// The fields are guarded by mu, since the stale handles may still read them while s is reused.
+type handleState struct {
+	mu     sync.Mutex
+	gen    uint64
+	pool   *BytesPool
+	origin []byte
+	data   []byte
+}
+
+var handleStates = sync.Pool{
+	New: func() interface{} { return new(handleState) },
+}
+
+// AllocHandle is like Alloc, but it returns a Handle which owns the bytes.
+func (bp *BytesPool) AllocHandle(size int) Handle {
+	origin, data := bp.alloc(size, 1)
+	s := handleStates.Get().(*handleState)
+	s.mu.Lock()
+	s.pool, s.origin, s.data = bp, origin, data
+	gen := s.gen
+	s.mu.Unlock()
+	return Handle{s: s, gen: gen}
+}
+
+// Bytes returns the data of the handle, or nil if it is freed already.
+// The data is valid until Free.
+func (h Handle) Bytes() []byte {
+	s := h.s
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.gen != h.gen {
+		return nil
+	}
+	return s.data
+}
+
+// Free frees the bytes to the pool, it is safe to be called more than once and concurrently,
+// only the first call frees the bytes and returns true.
+func (h Handle) Free() bool {
+	s := h.s
+	if s == nil {
+		return false
+	}
+	s.mu.Lock()
+	if s.gen != h.gen {
+		s.mu.Unlock()
+		return false
+	}
+	s.gen++
+	pool, origin := s.pool, s.origin
+	s.pool, s.origin, s.data = nil, nil, nil
+	s.mu.Unlock()
+	handleStates.Put(s)
+	pool.Free(origin)
+	return true
+}
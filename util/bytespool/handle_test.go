@@ -0,0 +1,89 @@
+// Copyright 2017 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bytespool
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+
+	. "github.com/pingcap/check"
+)
+
+func (s *testBytesPoolSuite) TestHandle(c *C) {
+	bp, err := NewBytesPoolWithOptions(WithDoubleFreeCheck())
+	c.Assert(err, IsNil)
+	c.Assert(Handle{}.Bytes(), IsNil)
+	c.Assert(Handle{}.Free(), IsFalse)
+
+	h := bp.AllocHandle(100)
+	c.Assert(h.Bytes(), HasLen, 100)
+	c.Assert(h.Free(), IsTrue)
+	c.Assert(h.Bytes(), IsNil)
+	// Freeing twice doesn't panic with the double free check.
+	c.Assert(h.Free(), IsFalse)
+
+	// A stale handle can't free the bytes of the next owner, even if the state is reused.
+	h2 := bp.AllocHandle(kilo)
+	c.Assert(h.Free(), IsFalse)
+	c.Assert(h2.Bytes(), HasLen, kilo)
+	c.Assert(h2.Free(), IsTrue)
+
+	// Only one of the concurrent holders frees the bytes.
+	for i := 0; i < 100; i++ {
+		h := bp.AllocHandle(kilo)
+		var freed int32
+		var wg sync.WaitGroup
+		for j := 0; j < 4; j++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if h.Free() {
+					atomic.AddInt32(&freed, 1)
+				}
+			}()
+		}
+		wg.Wait()
+		c.Assert(freed, Equals, int32(1))
+	}
+	c.Assert(bp.Stats().Frees, Equals, int64(102))
+}
+
+func (s *testBytesPoolSuite) TestHandleBytesConcurrentFree(c *C) {
+	bp := NewBytesPool()
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				h := bp.AllocHandle(100)
+				started, done := make(chan struct{}), make(chan struct{})
+				go func() {
+					defer close(done)
+					close(started)
+					// The reads racing with Free return nil or the data of h.
+					for b := h.Bytes(); b != nil; b = h.Bytes() {
+						c.Check(b, HasLen, 100)
+						runtime.Gosched()
+					}
+				}()
+				<-started
+				h.Free()
+				<-done
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,55 @@
+// Copyright 2017 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bytespool
+
+import (
+	"hash"
+)
+
+// HashingWriter writes to a PooledBuffer and updates a hash with the same bytes in one pass,
+// so a payload and its checksum are built without reading the buffer again. It is not thread-safe.
+type HashingWriter struct {
+	buf *PooledBuffer
+	h   hash.Hash
+}
+
+// NewHashingWriter creates a HashingWriter writing to buf and h, h is provided by the caller, like crc32.NewIEEE().
+func NewHashingWriter(buf *PooledBuffer, h hash.Hash) *HashingWriter {
+	return &HashingWriter{buf: buf, h: h}
+}
+
+// Write implements io.Writer interface, it never returns an error.
+func (w *HashingWriter) Write(p []byte) (int, error) {
+	w.h.Write(p)
+	return w.buf.Write(p)
+}
+
+// WriteString appends the content of s to the buffer and the hash, it never returns an error.
+func (w *HashingWriter) WriteString(s string) (int, error) {
+	// hash.Hash doesn't take strings, hash the copy in the buffer instead of converting s.
+	l := w.buf.Len()
+	n, err := w.buf.WriteString(s)
+	w.h.Write(w.buf.Bytes()[l:])
+	return n, err
+}
+
+// Sum appends the hash of the bytes written so far to b, it doesn't change the state of the hash.
+func (w *HashingWriter) Sum(b []byte) []byte {
+	return w.h.Sum(b)
+}
+
+// Buffer returns the PooledBuffer written to, the caller still owns it and should close it.
+func (w *HashingWriter) Buffer() *PooledBuffer {
+	return w.buf
+}
@@ -0,0 +1,54 @@
+// Copyright 2017 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bytespool
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"strings"
+
+	. "github.com/pingcap/check"
+)
+
+var _ io.Writer = &HashingWriter{}
+
+func (s *testBytesPoolSuite) TestHashingWriter(c *C) {
+	bp, err := NewBytesPoolWithConfig(kilo, 64*kilo, WithLeakTracking())
+	c.Assert(err, IsNil)
+	defer bp.AssertNoLeaks(c)
+	buf := NewPooledBuffer(bp, 0)
+	defer buf.Close()
+	w := NewHashingWriter(buf, crc32.NewIEEE())
+	var expect []byte
+	for i := 0; i < 1000; i++ {
+		fmt.Fprintf(w, "%d,", i)
+		expect = append(expect, fmt.Sprintf("%d,", i)...)
+	}
+	long := strings.Repeat("s", 1000)
+	w.WriteString(long)
+	expect = append(expect, long...)
+
+	c.Assert(string(w.Buffer().Bytes()), Equals, string(expect))
+	sum := crc32.ChecksumIEEE(expect)
+	c.Assert(w.Sum(nil), DeepEquals, []byte{byte(sum >> 24), byte(sum >> 16), byte(sum >> 8), byte(sum)})
+
+	// Any hash.Hash works.
+	buf2 := NewPooledBuffer(bp, 0)
+	defer buf2.Close()
+	w = NewHashingWriter(buf2, sha1.New())
+	w.WriteString("abc")
+	c.Assert(fmt.Sprintf("%x", w.Sum(nil)), Equals, "a9993e364706816aba3e25717850c26c9cd0d89d")
+}
@@ -0,0 +1,70 @@
+// Copyright 2017 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bytespool
+
+import (
+	"math/rand"
+
+	. "github.com/pingcap/check"
+)
+
+// checkRoundTrip allocates and frees the sizes in order, and checks the invariants of every allocation.
+func checkRoundTrip(c *C, bp *BytesPool, sizes []int) {
+	origins := make([][]byte, 0, len(sizes))
+	for _, size := range sizes {
+		origin, data := bp.Alloc(size)
+		c.Assert(data, HasLen, size, Commentf("size %d", size))
+		if size == 0 || size > bp.maxSize {
+			c.Assert(origin, IsNil, Commentf("size %d", size))
+			continue
+		}
+		n := len(origin)
+		c.Assert(isPowerOfTwo(n), IsTrue, Commentf("size %d", size))
+		c.Assert(n, Equals, bp.Capacity(size), Commentf("size %d", size))
+		c.Assert(n >= size && (n == bp.baseSize || n/2 < size), IsTrue, Commentf("size %d", size))
+		c.Assert(cap(data), Equals, n, Commentf("size %d", size))
+		origins = append(origins, origin)
+	}
+	// Free in a shuffled order, so the bytes are reused by other sizes.
+	for _, i := range rand.Perm(len(origins)) {
+		origin := origins[i]
+		c.Assert(bp.Free(origin), Equals, bp.bucketIdx(len(origin)))
+	}
+}
+
+func (s *testBytesPoolSuite) TestAllocFreeInvariants(c *C) {
+	bp, err := NewBytesPoolWithConfig(kilo, mega, WithDoubleFreeCheck())
+	c.Assert(err, IsNil)
+
+	// The boundaries of every bucket.
+	var seeds []int
+	for n := kilo; n <= mega; n *= 2 {
+		seeds = append(seeds, n-1, n, n+1)
+	}
+	seeds = append(seeds, 0, 1, mega+1)
+	checkRoundTrip(c, bp, seeds)
+	checkRoundTrip(c, bp, seeds)
+
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 50; i++ {
+		sizes := make([]int, r.Intn(32))
+		for j := range sizes {
+			// Pick a random bucket and a random size around it.
+			n := kilo << uint(r.Intn(11))
+			sizes[j] = n/2 + r.Intn(n+2)
+		}
+		checkRoundTrip(c, bp, sizes)
+	}
+	c.Assert(bp.Stats().FreeRejections, Equals, int64(0))
+}
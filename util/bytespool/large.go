@@ -0,0 +1,70 @@
+// Copyright 2017 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bytespool
+
+import (
+	"sync"
+)
+
+// largeSize rounds size up to a multiple of the large object granularity.
+// If the rounded size would overflow int, size is returned unchanged.
+func (bp *BytesPool) largeSize(size int) int {
+	g := bp.opts.largeGranularity
+	rem := size % g
+	if rem == 0 || size > maxInt-(g-rem) {
+		return size
+	}
+	return size + g - rem
+}
+
+// isLargeSize returns whether bytes of the size can be freed to the large object pool.
+func (bp *BytesPool) isLargeSize(size int) bool {
+	g := bp.opts.largeGranularity
+	return g > 0 && size > bp.maxSize && size%g == 0
+}
+
+// largePool returns the pool for the large objects of the size, the size should be rounded by largeSize.
+func (bp *BytesPool) largePool(size int) *sync.Pool {
+	if p, ok := bp.largePools.Load(size); ok {
+		return p.(*sync.Pool)
+	}
+	actual, _ := bp.largePools.LoadOrStore(size, new(sync.Pool))
+	return actual.(*sync.Pool)
+}
+
+// getLarge gets n bytes from the large object pool, skip is the number of its callers in the pool.
+func (bp *BytesPool) getLarge(n, size, skip int) (origin, data []byte) {
+	if v := bp.largePool(n).Get(); v != nil {
+		origin = v.([]byte)
+	} else {
+		origin = bp.newBytes(n)
+	}
+	if bp.tracker != nil {
+		bp.tracker.record(origin, skip+1)
+	}
+	if bp.profiler != nil {
+		bp.profiler.sample(len(origin), skip+1)
+	}
+	if bp.safetyNet != nil {
+		bp.arm(origin)
+	}
+	if bp.opts.allocHook != nil {
+		bp.opts.allocHook(size, bp.numBuckets)
+	}
+	return origin, origin[:size]
+}
+
+func (bp *BytesPool) putLarge(origin []byte) {
+	bp.largePool(len(origin)).Put(origin)
+}
@@ -0,0 +1,66 @@
+// Copyright 2017 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bytespool
+
+import (
+	. "github.com/pingcap/check"
+)
+
+func (s *testBytesPoolSuite) TestLargeObjectPool(c *C) {
+	_, err := NewBytesPoolWithOptions(WithLargeObjectPool(-1))
+	c.Assert(err, NotNil)
+
+	bp, err := NewBytesPoolWithConfig(kilo, 4*kilo, WithLargeObjectPool(4*kilo), WithLeakTracking())
+	c.Assert(err, IsNil)
+	// The small object path is unchanged.
+	origin, data := bp.Alloc(kilo)
+	c.Assert(len(origin), Equals, kilo)
+	c.Assert(bp.Free(origin), Equals, 0)
+
+	origin, data = bp.Alloc(5 * kilo)
+	c.Assert(origin, NotNil)
+	c.Assert(len(origin), Equals, 8*kilo)
+	c.Assert(len(data), Equals, 5*kilo)
+	c.Assert(bp.OutstandingAllocations(), HasLen, 1)
+	c.Assert(bp.Free(origin), Equals, 3)
+	c.Assert(bp.OutstandingAllocations(), HasLen, 0)
+
+	origin, _ = bp.Alloc(12 * kilo)
+	c.Assert(len(origin), Equals, 12*kilo)
+	c.Assert(bp.Return(origin), IsTrue)
+	c.Assert(bp.Return(make([]byte, 12*kilo+1)), IsFalse)
+	c.Assert(bp.Stats().Oversized, Equals, int64(2))
+
+	// Without the large object pool, oversized bytes can't be freed.
+	bp, err = NewBytesPoolWithConfig(kilo, 4*kilo)
+	c.Assert(err, IsNil)
+	origin, _ = bp.Alloc(5 * kilo)
+	c.Assert(origin, IsNil)
+	c.Assert(bp.Free(make([]byte, 8*kilo)), Equals, -1)
+}
+
+func (s *testBytesPoolSuite) TestLargeObjectPoolBudget(c *C) {
+	bp, err := NewBytesPoolWithConfig(kilo, 4*kilo, WithLargeObjectPool(4*kilo), WithBudget(16*kilo))
+	c.Assert(err, IsNil)
+	origin1, _ := bp.Alloc(5 * kilo)
+	c.Assert(bp.LiveBytes(), Equals, int64(8*kilo))
+	origin2, _, ok := bp.TryAlloc(7 * kilo)
+	c.Assert(ok, IsTrue)
+	c.Assert(len(origin2), Equals, 8*kilo)
+	_, _, ok = bp.TryAlloc(5 * kilo)
+	c.Assert(ok, IsFalse)
+	bp.Free(origin1)
+	bp.Free(origin2)
+	c.Assert(bp.LiveBytes(), Equals, int64(0))
+}
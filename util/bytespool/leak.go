@@ -0,0 +1,215 @@
+// Copyright 2017 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bytespool
+
+import (
+	"bytes"
+	"fmt"
+	"runtime"
+	"sync"
+	"time"
+	"unsafe"
+)
+
+const maxStackDepth = 32
+
+// Allocation is an allocation which is not freed yet, recorded by leak tracking.
+type Allocation struct {
+	// Size is the length of the origin bytes.
+	Size int
+	// Stack is the program counters of the goroutine which allocated the bytes.
+	Stack []uintptr
+	// Time is when the bytes are allocated.
+	Time time.Time
+	// Pool is the name of the pool which allocated the bytes.
+	Pool string
+}
+
+// String implements fmt.Stringer interface, it prints the size and the allocation stack.
+func (a Allocation) String() string {
+	var buf bytes.Buffer
+	if a.Pool != "" {
+		fmt.Fprintf(&buf, "%d bytes allocated from pool %s at:\n", a.Size, a.Pool)
+	} else {
+		fmt.Fprintf(&buf, "%d bytes allocated at:\n", a.Size)
+	}
+	frames := runtime.CallersFrames(a.Stack)
+	for {
+		frame, more := frames.Next()
+		fmt.Fprintf(&buf, "\t%s\n\t\t%s:%d\n", frame.Function, frame.File, frame.Line)
+		if !more {
+			break
+		}
+	}
+	return buf.String()
+}
+
+// leakTracker records the outstanding allocations keyed by the data pointer of the origin bytes.
+type leakTracker struct {
+	sync.Mutex
+	allocs map[uintptr]Allocation
+	// withStack is false if only the double free check needs the tracker.
+	withStack bool
+	// name is the name of the pool.
+	name string
+	// holds is the histogram of the hold durations of the freed allocations.
+	holds HoldDurationHistogram
+}
+
+func newLeakTracker(withStack bool, name string) *leakTracker {
+	return &leakTracker{
+		allocs:    make(map[uintptr]Allocation),
+		withStack: withStack,
+		name:      name,
+		holds:     make(HoldDurationHistogram, len(HoldDurationBounds)+1),
+	}
+}
+
+func bytesPointer(b []byte) uintptr {
+	return uintptr(unsafe.Pointer(&b[0]))
+}
+
+// record records the allocation of origin, skip is the number of the callers of record in the pool,
+// like 3 for BytesPool.get, alloc and Alloc, so the recorded stack starts from the caller of the exported method.
+func (t *leakTracker) record(origin []byte, skip int) {
+	a := Allocation{Size: len(origin), Time: time.Now(), Pool: t.name}
+	if t.withStack {
+		pcs := make([]uintptr, maxStackDepth)
+		// Skip runtime.Callers, record and the callers in the pool.
+		n := runtime.Callers(2+skip, pcs)
+		a.Stack = pcs[:n]
+	}
+	t.Lock()
+	t.allocs[bytesPointer(origin)] = a
+	t.Unlock()
+}
+
+// forget removes the allocation, it returns false if the allocation is not recorded.
+func (t *leakTracker) forget(origin []byte) bool {
+	ptr := bytesPointer(origin)
+	t.Lock()
+	a, ok := t.allocs[ptr]
+	if ok {
+		delete(t.allocs, ptr)
+		t.holds.observe(time.Since(a.Time))
+	}
+	t.Unlock()
+	return ok
+}
+
+func (t *leakTracker) holdDurations() HoldDurationHistogram {
+	t.Lock()
+	holds := make(HoldDurationHistogram, len(t.holds))
+	copy(holds, t.holds)
+	t.Unlock()
+	return holds
+}
+
+// HoldDurationBounds is the upper bounds of the buckets of HoldDurationHistogram.
+var HoldDurationBounds = []time.Duration{
+	time.Microsecond,
+	10 * time.Microsecond,
+	100 * time.Microsecond,
+	time.Millisecond,
+	10 * time.Millisecond,
+	100 * time.Millisecond,
+	time.Second,
+	10 * time.Second,
+}
+
+// HoldDurationHistogram is the distribution of how long the allocations are held before Free.
+// The i-th element counts the durations not longer than HoldDurationBounds[i],
+// and the last element counts the ones longer than all the bounds.
+type HoldDurationHistogram []int64
+
+func (h HoldDurationHistogram) observe(d time.Duration) {
+	i := 0
+	for i < len(HoldDurationBounds) && d > HoldDurationBounds[i] {
+		i++
+	}
+	h[i]++
+}
+
+// Count returns the number of the observed durations.
+func (h HoldDurationHistogram) Count() int64 {
+	var n int64
+	for _, c := range h {
+		n += c
+	}
+	return n
+}
+
+// OutstandingAllocations returns the allocations which are not freed yet.
+// It returns nil if the pool is not created with WithLeakTracking.
+// Allocations larger than the max size are not recorded unless the large object pool is enabled.
+func (bp *BytesPool) OutstandingAllocations() []Allocation {
+	if !bp.opts.leakTracking {
+		return nil
+	}
+	t := bp.tracker
+	t.Lock()
+	allocs := make([]Allocation, 0, len(t.allocs))
+	for _, a := range t.allocs {
+		allocs = append(allocs, a)
+	}
+	t.Unlock()
+	return allocs
+}
+
+// ForEachOutstanding calls fn with the data pointer, the size and the allocation stack of every allocation
+// which is not freed yet, which helps to correlate the pooled bytes in a heap profile with their holders.
+// The allocations are snapshotted under the lock and fn is called after unlocking, so fn may use the pool.
+// It does nothing if the pool is not created with WithLeakTracking.
+func (bp *BytesPool) ForEachOutstanding(fn func(ptr uintptr, size int, stack []uintptr)) {
+	if !bp.opts.leakTracking {
+		return
+	}
+	t := bp.tracker
+	t.Lock()
+	ptrs := make([]uintptr, 0, len(t.allocs))
+	allocs := make([]Allocation, 0, len(t.allocs))
+	for ptr, a := range t.allocs {
+		ptrs = append(ptrs, ptr)
+		allocs = append(allocs, a)
+	}
+	t.Unlock()
+	for i, a := range allocs {
+		fn(ptrs[i], a.Size, a.Stack)
+	}
+}
+
+// ErrorReporter is the part of testing.TB used by AssertNoLeaks, *check.C satisfies it as well.
+// It keeps the package from importing testing, which would register the test flags in production binaries.
+type ErrorReporter interface {
+	Errorf(format string, args ...interface{})
+}
+
+// AssertNoLeaks fails t with the allocation stacks if any allocation is not freed yet,
+// it is used like `defer pool.AssertNoLeaks(t)` at the beginning of a test.
+// It fails t as well if the pool is not created with WithLeakTracking, where leaks can't be detected.
+func (bp *BytesPool) AssertNoLeaks(t ErrorReporter) {
+	if !bp.opts.leakTracking {
+		t.Errorf("bytespool: can't check leaks of pool %q, it is not created with WithLeakTracking", bp.opts.name)
+		return
+	}
+	allocs := bp.OutstandingAllocations()
+	if len(allocs) == 0 {
+		return
+	}
+	var buf bytes.Buffer
+	for _, a := range allocs {
+		buf.WriteString(a.String())
+	}
+	t.Errorf("bytespool: %d allocations are not freed:\n%s", len(allocs), buf.String())
+}
@@ -0,0 +1,225 @@
+// Copyright 2017 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bytespool
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+	"time"
+
+	. "github.com/pingcap/check"
+	goctx "golang.org/x/net/context"
+)
+
+func (s *testBytesPoolSuite) TestLeakTracking(c *C) {
+	bp := NewBytesPool()
+	origin, _ := bp.Alloc(kilo)
+	c.Assert(bp.OutstandingAllocations(), IsNil)
+	bp.Free(origin)
+
+	bp, err := NewBytesPoolWithOptions(WithLeakTracking())
+	c.Assert(err, IsNil)
+	origin1, _ := bp.Alloc(100)
+	origin2, _ := bp.Alloc(3 * kilo)
+	bp.Alloc(129 * mega)
+	allocs := bp.OutstandingAllocations()
+	c.Assert(allocs, HasLen, 2)
+	for _, a := range allocs {
+		c.Assert(a.Size == kilo || a.Size == 4*kilo, IsTrue)
+		c.Assert(a.String(), Matches, "(?s).*TestLeakTracking.*")
+	}
+
+	bp.Free(origin1)
+	allocs = bp.OutstandingAllocations()
+	c.Assert(allocs, HasLen, 1)
+	c.Assert(allocs[0].Size, Equals, 4*kilo)
+	c.Assert(strings.HasPrefix(allocs[0].String(), "4096 bytes allocated at:"), IsTrue)
+	bp.Free(origin2)
+	c.Assert(bp.OutstandingAllocations(), HasLen, 0)
+}
+
+func (s *testBytesPoolSuite) TestDoubleFreeCheck(c *C) {
+	bp, err := NewBytesPoolWithOptions(WithDoubleFreeCheck())
+	c.Assert(err, IsNil)
+	origin, _ := bp.Alloc(kilo)
+	c.Assert(bp.OutstandingAllocations(), IsNil)
+	c.Assert(bp.Free(origin), Equals, 0)
+	c.Assert(func() { bp.Free(origin) }, PanicMatches, "bytespool: free 1024 bytes .* already freed or not allocated by the pool")
+	c.Assert(func() { bp.Free(make([]byte, 2*kilo)) }, PanicMatches, "bytespool: free 2048 bytes .*")
+	c.Assert(bp.Free(make([]byte, 100)), Equals, -1)
+
+	// Freeing again is fine after the bytes are allocated again.
+	origin, _ = bp.Alloc(kilo)
+	c.Assert(bp.Free(origin), Equals, 0)
+
+	// Without the check, freeing twice is not detected.
+	bp = NewBytesPool()
+	origin, _ = bp.Alloc(kilo)
+	c.Assert(bp.Free(origin), Equals, 0)
+	c.Assert(bp.Free(origin), Equals, 0)
+}
+
+func (s *testBytesPoolSuite) TestFreeAppendGrown(c *C) {
+	bp, err := NewBytesPoolWithConfig(kilo, 4*kilo, WithDoubleFreeCheck())
+	c.Assert(err, IsNil)
+	origin, data := bp.Alloc(kilo)
+	data = append(data, make([]byte, kilo)...)
+	c.Assert(&data[0] != &origin[0], IsTrue)
+	c.Assert(func() { bp.Free(data) }, PanicMatches, "bytespool: free 2048 bytes .* not allocated by the pool")
+	// The foreign array is not pooled, and the origin is still outstanding.
+	c.Assert(bp.Stats().Frees, Equals, int64(0))
+	c.Assert(bp.Free(origin), Equals, 0)
+}
+
+func (s *testBytesPoolSuite) TestPoisonOnFree(c *C) {
+	bp, err := NewBytesPoolWithOptions(WithPoisonOnFree(), WithDoubleFreeCheck())
+	c.Assert(err, IsNil)
+	origin, data := bp.Alloc(3 * kilo)
+	for i := range data {
+		data[i] = 1
+	}
+	c.Assert(bp.Free(origin), Equals, 2)
+	for i := range origin {
+		c.Assert(origin[i], Equals, poisonPattern[i%2], Commentf("index %d", i))
+	}
+	c.Assert(func() { bp.Free(origin) }, PanicMatches, "bytespool: free 4096 bytes .*")
+
+	b := []byte{1, 1, 1}
+	poison(b)
+	c.Assert(b, DeepEquals, []byte{0xDE, 0xAD, 0xDE})
+	b = []byte{1}
+	poison(b)
+	c.Assert(b, DeepEquals, []byte{0xDE})
+	poison(nil)
+}
+
+func (s *testBytesPoolSuite) TestHoldDurations(c *C) {
+	c.Assert(NewBytesPool().Stats().HoldDurations, IsNil)
+
+	bp, err := NewBytesPoolWithOptions(WithDoubleFreeCheck())
+	c.Assert(err, IsNil)
+	origin1, _ := bp.Alloc(kilo)
+	origin2, _ := bp.Alloc(kilo)
+	bp.Free(origin1)
+	time.Sleep(2 * time.Millisecond)
+	bp.Free(origin2)
+	bp.Free(make([]byte, 100))
+	holds := bp.Stats().HoldDurations
+	c.Assert(holds, HasLen, len(HoldDurationBounds)+1)
+	c.Assert(holds.Count(), Equals, int64(2))
+	// The second one is held longer than 1ms.
+	var longer int64
+	for i := 4; i < len(holds); i++ {
+		longer += holds[i]
+	}
+	c.Assert(longer >= 1, IsTrue)
+
+	h := make(HoldDurationHistogram, len(HoldDurationBounds)+1)
+	h.observe(0)
+	h.observe(time.Microsecond)
+	h.observe(time.Hour)
+	c.Assert(h[0], Equals, int64(2))
+	c.Assert(h[len(h)-1], Equals, int64(1))
+}
+
+func (s *testBytesPoolSuite) TestForEachOutstanding(c *C) {
+	called := false
+	NewBytesPool().ForEachOutstanding(func(uintptr, int, []uintptr) { called = true })
+	c.Assert(called, IsFalse)
+
+	bp, err := NewBytesPoolWithOptions(WithLeakTracking())
+	c.Assert(err, IsNil)
+	origin1, _ := bp.Alloc(kilo)
+	origin2, _ := bp.Alloc(3 * kilo)
+	sizes := make(map[uintptr]int)
+	bp.ForEachOutstanding(func(ptr uintptr, size int, stack []uintptr) {
+		c.Assert(len(stack) > 0, IsTrue)
+		sizes[ptr] = size
+		// The pool can be used in fn.
+		origin, _ := bp.Alloc(kilo)
+		bp.Free(origin)
+	})
+	c.Assert(sizes, DeepEquals, map[uintptr]int{
+		bytesPointer(origin1): kilo,
+		bytesPointer(origin2): 4 * kilo,
+	})
+}
+
+type mockT struct {
+	errors []string
+}
+
+func (t *mockT) Errorf(format string, args ...interface{}) {
+	t.errors = append(t.errors, fmt.Sprintf(format, args...))
+}
+
+func (s *testBytesPoolSuite) TestAssertNoLeaks(c *C) {
+	var t mockT
+	NewBytesPool().AssertNoLeaks(&t)
+	c.Assert(t.errors, HasLen, 1)
+	c.Assert(t.errors[0], Matches, ".*not created with WithLeakTracking")
+
+	bp, err := NewBytesPoolWithConfig(kilo, 4*kilo, WithLeakTracking())
+	c.Assert(err, IsNil)
+	defer bp.AssertNoLeaks(c)
+	t = mockT{}
+	origin, _ := bp.Alloc(kilo)
+	bp.AssertNoLeaks(&t)
+	c.Assert(t.errors, HasLen, 1)
+	c.Assert(t.errors[0], Matches, "(?s)bytespool: 1 allocations are not freed:.*TestAssertNoLeaks.*")
+	bp.Free(origin)
+
+	t = mockT{}
+	bp.AssertNoLeaks(&t)
+	c.Assert(t.errors, HasLen, 0)
+}
+
+func (s *testBytesPoolSuite) TestLeakTrackingCallers(c *C) {
+	// The recorded stacks start from the caller of every allocation method, not from the wrappers in the pool.
+	cases := []struct {
+		name  string
+		opts  []Option
+		alloc func(bp *BytesPool)
+	}{
+		{"Alloc", nil, func(bp *BytesPool) { bp.Alloc(100) }},
+		{"Alloc large", []Option{WithLargeObjectPool(mega)}, func(bp *BytesPool) { bp.Alloc(5 * mega) }},
+		{"Alloc slab", []Option{WithSlab(512)}, func(bp *BytesPool) { bp.Alloc(100) }},
+		{"Alloc1K", nil, func(bp *BytesPool) { bp.Alloc1K() }},
+		{"Alloc4K fallback", []Option{WithRoundUp(3 * kilo)}, func(bp *BytesPool) { bp.Alloc4K() }},
+		{"AllocAligned", nil, func(bp *BytesPool) { bp.AllocAligned(100, 64) }},
+		{"AllocZeroed", nil, func(bp *BytesPool) { bp.AllocZeroed(100) }},
+		{"AllocForWrite", nil, func(bp *BytesPool) { bp.AllocForWrite(100) }},
+		{"AllocAppendable", nil, func(bp *BytesPool) { bp.AllocAppendable(100) }},
+		{"TryAllocPooled", nil, func(bp *BytesPool) { bp.TryAllocPooled(100) }},
+		{"TryAlloc", []Option{WithBudget(mega)}, func(bp *BytesPool) { bp.TryAlloc(100) }},
+		{"TryAlloc large", []Option{WithBudget(8 * mega), WithLargeObjectPool(kilo)}, func(bp *BytesPool) { bp.TryAlloc(5 * mega) }},
+		{"AllocContext", []Option{WithBudget(mega)}, func(bp *BytesPool) { bp.AllocContext(goctx.Background(), 100) }},
+		{"AllocMany", nil, func(bp *BytesPool) { bp.AllocMany([]int{100}) }},
+		{"Realloc", nil, func(bp *BytesPool) { bp.Realloc(nil, nil, 100) }},
+		{"Reuse", nil, func(bp *BytesPool) { bp.Reuse(nil, 100) }},
+		{"AllocTagged", nil, func(bp *BytesPool) { bp.AllocTagged("t", 100) }},
+		{"AllocHandle", nil, func(bp *BytesPool) { bp.AllocHandle(100) }},
+		{"Scope.Alloc", nil, func(bp *BytesPool) { bp.NewScope().Alloc(100) }},
+	}
+	for _, t := range cases {
+		bp, err := NewBytesPoolWithConfig(kilo, 4*mega, append(t.opts, WithLeakTracking())...)
+		c.Assert(err, IsNil)
+		t.alloc(bp)
+		allocs := bp.OutstandingAllocations()
+		c.Assert(allocs, HasLen, 1, Commentf("%s", t.name))
+		frame, _ := runtime.CallersFrames(allocs[0].Stack).Next()
+		c.Assert(strings.Contains(frame.Function, "TestLeakTrackingCallers"), IsTrue, Commentf("%s: %s", t.name, allocs[0]))
+	}
+}
@@ -0,0 +1,130 @@
+// Copyright 2017 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bytespool
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// mmapBuf is a mapped region, it is unmapped by the finalizer once GC drops it from the idle pool.
+type mmapBuf struct {
+	b []byte
+}
+
+// mmapAnon maps the anonymous bytes, it is a variable so the tests can inject failures.
+var mmapAnon = mmapBytes
+
+func newMmapBuf(size int) (*mmapBuf, error) {
+	b, err := mmapAnon(size)
+	if err != nil {
+		return nil, err
+	}
+	m := &mmapBuf{b: b}
+	runtime.SetFinalizer(m, func(m *mmapBuf) {
+		munmapBytes(m.b)
+	})
+	return m, nil
+}
+
+// mmapPool holds the buckets backed by mmap. The mapped bytes in use are referenced by inUse,
+// so they are never unmapped while the caller holds them.
+type mmapPool struct {
+	// from is the index of the first bucket backed by mmap.
+	from int
+	idle []sync.Pool
+	// numa binds the bytes to the node of the calling thread when they are handed out if WithNUMA is used.
+	numa bool
+
+	mu    sync.Mutex
+	inUse map[uintptr]*mmapBuf
+}
+
+func newMmapPool(from, numBuckets int, numa bool) *mmapPool {
+	return &mmapPool{
+		from:  from,
+		idle:  make([]sync.Pool, numBuckets-from),
+		numa:  numa,
+		inUse: make(map[uintptr]*mmapBuf),
+	}
+}
+
+// get gets n bytes from the i-th bucket, it falls back to make if mmap fails and returns false,
+// such bytes are not owned by the pool and can't be freed to it.
+func (p *mmapPool) get(i, n int, misses *int64) ([]byte, bool) {
+	var m *mmapBuf
+	if v := p.idle[i-p.from].Get(); v != nil {
+		m = v.(*mmapBuf)
+	} else {
+		atomic.AddInt64(misses, 1)
+		var err error
+		if m, err = newMmapBuf(n); err != nil {
+			return make([]byte, n), false
+		}
+	}
+	if p.numa {
+		// The pages are released by put, so the pages faulted in by the caller follow the policy.
+		// The binding is best effort, the bytes are still usable if mbind fails.
+		bindNode(m.b, currentNode())
+	}
+	p.mu.Lock()
+	p.inUse[bytesPointer(m.b)] = m
+	p.mu.Unlock()
+	return m.b, true
+}
+
+func (p *mmapPool) owns(origin []byte) bool {
+	p.mu.Lock()
+	_, ok := p.inUse[bytesPointer(origin)]
+	p.mu.Unlock()
+	return ok
+}
+
+// put releases the pages of origin and puts it back to the i-th bucket.
+func (p *mmapPool) put(i int, origin []byte) {
+	ptr := bytesPointer(origin)
+	p.mu.Lock()
+	m := p.inUse[ptr]
+	delete(p.inUse, ptr)
+	p.mu.Unlock()
+	if m == nil {
+		return
+	}
+	releasePages(m.b)
+	p.idle[i-p.from].Put(m)
+}
+
+// discard drops origin without pooling it, it is unmapped after GC.
+func (p *mmapPool) discard(origin []byte) {
+	p.mu.Lock()
+	delete(p.inUse, bytesPointer(origin))
+	p.mu.Unlock()
+}
+
+func (p *mmapPool) prefill(i, n, count int) {
+	for j := 0; j < count; j++ {
+		m, err := newMmapBuf(n)
+		if err != nil {
+			return
+		}
+		p.idle[i-p.from].Put(m)
+	}
+}
+
+func (p *mmapPool) drain() {
+	for i := range p.idle {
+		drain(&p.idle[i])
+	}
+}
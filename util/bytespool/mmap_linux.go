@@ -0,0 +1,55 @@
+// Copyright 2017 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bytespool
+
+import (
+	"io/ioutil"
+	"os"
+	"syscall"
+
+	"github.com/juju/errors"
+)
+
+const mmapSupported = true
+
+func mmapBytes(size int) ([]byte, error) {
+	return syscall.Mmap(-1, 0, size, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_ANON|syscall.MAP_PRIVATE)
+}
+
+func munmapBytes(b []byte) error {
+	return syscall.Munmap(b)
+}
+
+// releasePages returns the physical pages of b to the OS, they are zero filled on the next access.
+func releasePages(b []byte) {
+	syscall.Madvise(b, syscall.MADV_DONTNEED)
+}
+
+// mmapFile maps a temp file of size bytes in dir, the file is removed once mapped,
+// so it is released when the mapping is unmapped, even if the process crashes.
+func mmapFile(dir string, size int) ([]byte, error) {
+	f, err := ioutil.TempFile(dir, "bytespool")
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer func() {
+		f.Close()
+		os.Remove(f.Name())
+	}()
+	if err = f.Truncate(int64(size)); err != nil {
+		return nil, errors.Trace(err)
+	}
+	b, err := syscall.Mmap(int(f.Fd()), 0, size, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+	return b, errors.Trace(err)
+}
@@ -0,0 +1,38 @@
+// Copyright 2017 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !linux
+// +build !linux
+
+package bytespool
+
+import (
+	"github.com/juju/errors"
+)
+
+// mmapSupported is false, so WithMmapThreshold is ignored and the functions below are never called.
+const mmapSupported = false
+
+func mmapBytes(size int) ([]byte, error) {
+	return nil, errors.New("mmap is not supported")
+}
+
+func munmapBytes(b []byte) error {
+	return nil
+}
+
+func releasePages(b []byte) {}
+
+func mmapFile(dir string, size int) ([]byte, error) {
+	return nil, errors.New("mmap is not supported")
+}
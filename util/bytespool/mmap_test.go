@@ -0,0 +1,94 @@
+// Copyright 2017 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bytespool
+
+import (
+	"errors"
+	"runtime"
+
+	. "github.com/pingcap/check"
+)
+
+func (s *testBytesPoolSuite) TestMmapThreshold(c *C) {
+	_, err := NewBytesPoolWithOptions(WithMmapThreshold(-1))
+	c.Assert(err, NotNil)
+
+	bp, err := NewBytesPoolWithConfig(kilo, mega, WithMmapThreshold(100*kilo), WithDoubleFreeCheck())
+	c.Assert(err, IsNil)
+	if !mmapSupported {
+		c.Assert(bp.mmaps, IsNil)
+		return
+	}
+	c.Assert(bp.mmaps.from, Equals, 7)
+
+	// Small buckets are not mapped.
+	origin, _ := bp.Alloc(kilo)
+	c.Assert(bp.mmaps.owns(origin), IsFalse)
+	c.Assert(bp.Free(origin), Equals, 0)
+
+	origin, data := bp.Alloc(200 * kilo)
+	c.Assert(len(origin), Equals, 256*kilo)
+	c.Assert(len(data), Equals, 200*kilo)
+	c.Assert(bp.mmaps.owns(origin), IsTrue)
+	for i := range data {
+		data[i] = 0xff
+	}
+	c.Assert(bp.Free(origin), Equals, 8)
+	c.Assert(bp.mmaps.owns(origin), IsFalse)
+
+	// The pages are released on Free, so the reused bytes are zero.
+	origin, data = bp.Alloc(200 * kilo)
+	if bp.Stats().Buckets[8].Misses == 1 {
+		c.Assert(data[0], Equals, byte(0))
+	}
+	c.Assert(bp.Free(origin), Equals, 8)
+
+	// Bytes which are not mapped by the pool are rejected by the mapped buckets.
+	c.Assert(bp.Free(make([]byte, 256*kilo)), Equals, -1)
+
+	bp.Prefill(mega, 2)
+	bp.Clear()
+	runtime.GC()
+}
+
+func (s *testBytesPoolSuite) TestMmapFailure(c *C) {
+	if !mmapSupported {
+		c.Skip("mmap is not supported")
+	}
+	bp, err := NewBytesPoolWithConfig(kilo, mega, WithMmapThreshold(100*kilo), WithBudget(mega), WithLeakTracking())
+	c.Assert(err, IsNil)
+	mmapAnon = func(int) ([]byte, error) {
+		return nil, errors.New("mmap error")
+	}
+	defer func() {
+		mmapAnon = mmapBytes
+	}()
+
+	for i := 0; i < 10; i++ {
+		origin, data, ok := bp.TryAlloc(mega)
+		c.Assert(ok, IsTrue)
+		c.Assert(origin, IsNil)
+		c.Assert(data, HasLen, mega)
+		c.Assert(bp.LiveBytes(), Equals, int64(0))
+		c.Assert(bp.Free(origin), Equals, -1)
+	}
+	origin, data := bp.Alloc(200 * kilo)
+	c.Assert(origin, IsNil)
+	c.Assert(data, HasLen, 200*kilo)
+	st := bp.Stats()
+	c.Assert(st.Buckets[8].Live, Equals, int64(0))
+	c.Assert(st.Buckets[8].Misses, Equals, int64(1))
+	c.Assert(bp.LiveBytes(), Equals, int64(0))
+	c.Assert(bp.OutstandingAllocations(), HasLen, 0)
+}
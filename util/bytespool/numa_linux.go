@@ -0,0 +1,81 @@
+// Copyright 2017 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bytespool
+
+import (
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+const nodeOnlinePath = "/sys/devices/system/node/online"
+
+// numaNodes returns the number of the NUMA nodes, it is the largest node ID plus one, like 2 for "0-1".
+func numaNodes() int {
+	b, err := ioutil.ReadFile(nodeOnlinePath)
+	if err != nil {
+		return 1
+	}
+	return parseNodeList(strings.TrimSpace(string(b)))
+}
+
+// parseNodeList parses the node list like "0-1,3", it returns 1 if the list is invalid.
+func parseNodeList(list string) int {
+	max := -1
+	for _, part := range strings.Split(list, ",") {
+		if i := strings.LastIndexByte(part, '-'); i >= 0 {
+			part = part[i+1:]
+		}
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return 1
+		}
+		if n > max {
+			max = n
+		}
+	}
+	return max + 1
+}
+
+// currentNode returns the NUMA node of the CPU which the calling thread runs on.
+func currentNode() int {
+	var cpu, node uint32
+	_, _, errno := syscall.RawSyscall(sysGetcpu, uintptr(unsafe.Pointer(&cpu)), uintptr(unsafe.Pointer(&node)), 0)
+	if errno != 0 {
+		return 0
+	}
+	return int(node)
+}
+
+// mpolPreferred is MPOL_PREFERRED of mbind, the pages are allocated on the node if it has free memory.
+const mpolPreferred = 1
+
+// bindNode sets the memory policy of b to prefer the node, b should be mapped by mmap.
+// It only affects the pages faulted in later, so b should have no pages yet or have them released.
+func bindNode(b []byte, node int) error {
+	if len(b) == 0 || node < 0 {
+		return nil
+	}
+	mask := make([]uint64, node/64+1)
+	mask[node/64] = 1 << uint(node%64)
+	// maxnode is the number of bits in mask plus one, the kernel ignores the last bit.
+	_, _, errno := syscall.Syscall6(syscall.SYS_MBIND, uintptr(unsafe.Pointer(&b[0])), uintptr(len(b)), mpolPreferred,
+		uintptr(unsafe.Pointer(&mask[0])), uintptr(len(mask)*64+1), 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+// Copyright 2017 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bytespool
+
+import (
+	"syscall"
+	"testing"
+
+	. "github.com/pingcap/check"
+)
+
+func (s *testBytesPoolSuite) TestNUMA(c *C) {
+	c.Assert(parseNodeList("0"), Equals, 1)
+	c.Assert(parseNodeList("0-1"), Equals, 2)
+	c.Assert(parseNodeList("0-1,3"), Equals, 4)
+	c.Assert(parseNodeList(""), Equals, 1)
+	c.Assert(parseNodeList("x"), Equals, 1)
+
+	_, err := NewBytesPoolWithOptions(WithNUMA(), WithShards(2))
+	c.Assert(err, NotNil)
+	bp, err := NewBytesPoolWithOptions(WithNUMA())
+	c.Assert(err, IsNil)
+	c.Assert(bp.loadShards(), HasLen, numaNodes())
+	node := currentNode()
+	c.Assert(node >= 0 && node < numaNodes(), IsTrue)
+	for i := 0; i < 10; i++ {
+		origin, _ := bp.Alloc(kilo)
+		c.Assert(bp.Free(origin), Equals, 0)
+	}
+	c.Assert(bp.Stats().Gets, Equals, int64(10))
+}
+
+func (s *testBytesPoolSuite) TestNUMAMmap(c *C) {
+	b, err := mmapBytes(64 * kilo)
+	c.Assert(err, IsNil)
+	defer munmapBytes(b)
+	if err = bindNode(b, currentNode()); err == syscall.EPERM || err == syscall.ENOSYS {
+		c.Skip("mbind is not permitted")
+	}
+	c.Assert(err, IsNil)
+	c.Assert(bindNode(nil, 0), IsNil)
+
+	bp, err := NewBytesPoolWithConfig(kilo, mega, WithNUMA(), WithMmapThreshold(64*kilo))
+	c.Assert(err, IsNil)
+	c.Assert(bp.mmaps.numa, IsTrue)
+	for i := 0; i < 10; i++ {
+		origin, data := bp.Alloc(100 * kilo)
+		c.Assert(bp.mmaps.owns(origin), IsTrue)
+		for j := 0; j < len(data); j += 4 * kilo {
+			data[j] = 1
+		}
+		c.Assert(bp.Free(origin), Equals, 7)
+	}
+}
+
+// BenchmarkNUMA runs on all the CPUs, on a multi-node machine the bytes are reused by the same node.
+// On a single node it only measures the overhead of getcpu.
+func BenchmarkNUMA(b *testing.B) {
+	bp, _ := NewBytesPoolWithOptions(WithNUMA())
+	benchmarkParallel(b, bp)
+}
+
+// BenchmarkNUMAMmap backs the 64KB buckets by mmap, whose pages are bound to the local node,
+// so on a multi-node machine the pages touched by the benchmark are local, compared to BenchmarkNUMAMmapDisabled.
+func BenchmarkNUMAMmap(b *testing.B) {
+	bp, _ := NewBytesPoolWithOptions(WithNUMA(), WithMmapThreshold(64*kilo))
+	benchmarkParallel(b, bp)
+}
+
+func BenchmarkNUMAMmapDisabled(b *testing.B) {
+	bp, _ := NewBytesPoolWithOptions(WithMmapThreshold(64 * kilo))
+	benchmarkParallel(b, bp)
+}
+
+func BenchmarkNUMADisabled(b *testing.B) {
+	benchmarkParallel(b, NewBytesPool())
+}
+
+func benchmarkParallel(b *testing.B, bp *BytesPool) {
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			origin, data := bp.Alloc(64 * kilo)
+			for i := 0; i < len(data); i += 4 * kilo {
+				data[i]++
+			}
+			bp.Free(origin)
+		}
+	})
+}
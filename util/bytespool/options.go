@@ -0,0 +1,442 @@
+// Copyright 2017 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bytespool
+
+import (
+	"time"
+
+	"github.com/juju/errors"
+)
+
+type options struct {
+	// name identifies the pool in the statistics, metrics and leak reports.
+	name string
+
+	leakTracking    bool
+	doubleFreeCheck bool
+	reslicedCheck   bool
+	poisonOnFree    bool
+	budget          int64
+	// register adds the pool to RegisteredPools.
+	register bool
+	// finalizerSafetyNet makes the pool free the leaked origins by finalizers.
+	finalizerSafetyNet bool
+	// largeGranularity is the size step of the large object pool, 0 means the pool is disabled.
+	largeGranularity int
+	// shards is the number of shards in sharded mode, 0 means the pool is not sharded.
+	shards int
+	// numa makes the pool have a shard per NUMA node.
+	numa bool
+
+	// retain makes the buckets hold the idle bytes by free lists instead of sync.Pool.
+	retain bool
+	// heapBelow is the min size to be pooled, the smaller sizes are made on the heap.
+	heapBelow int
+	// autoTrimInterval and autoTrimKeep configure the background trimmer, which is disabled if the interval is 0.
+	autoTrimInterval time.Duration
+	autoTrimKeep     int64
+	// warmRatio is the ratio of the idle bytes to the allocations of a bucket kept by the warmer, 0 means no warmer.
+	warmRatio float64
+	// memoryLimit makes the trimmer keep less bytes as the heap approaches it, 0 means no limit.
+	memoryLimit int64
+	// arenaSize is the size of the arena reserved for the buckets, 0 means no arena.
+	arenaSize int
+	// slabThreshold is the max size served by the slab, 0 means the slab is not used.
+	slabThreshold int
+	// spillThreshold and spillDir configure the disk spill tier, which is disabled if the threshold is 0.
+	spillThreshold int
+	spillDir       string
+	// profileDepth and profileRate configure the call site profiler, which is disabled if the depth is 0.
+	profileDepth int
+	profileRate  int
+	// sizeHistogram makes the pool record the requested sizes.
+	sizeHistogram bool
+	// growthFactor is the ratio between the sizes of adjacent buckets, 0 means the powers of two.
+	growthFactor float64
+	// roundUp is the granularity the bucket sizes are rounded up to before selecting the bucket, 0 means no rounding.
+	roundUp int
+	// maxIdle is the max number of idle bytes held by each bucket, 0 means no limit.
+	maxIdle int
+
+	// mmapThreshold is the smallest bucket size backed by mmap, 0 means mmap is not used.
+	mmapThreshold int
+
+	allocator func(size int) []byte
+	allocHook func(size, bucket int)
+	freeHook  func(origin []byte, bucket int)
+}
+
+func (o *options) validate() error {
+	if o.budget < 0 {
+		return errors.Errorf("invalid budget %d, should not be negative", o.budget)
+	}
+	if o.shards < 0 {
+		return errors.Errorf("invalid shards %d, should not be negative", o.shards)
+	}
+	if o.numa && o.shards > 0 {
+		return errors.New("NUMA mode can't be used with shards")
+	}
+	if o.heapBelow < 0 {
+		return errors.Errorf("invalid min pooled size %d, should not be negative", o.heapBelow)
+	}
+	if o.autoTrimInterval < 0 || o.autoTrimKeep < 0 {
+		return errors.Errorf("invalid auto trim interval %v and keep bytes %d, should not be negative",
+			o.autoTrimInterval, o.autoTrimKeep)
+	}
+	if o.autoTrimInterval > 0 && !o.retain {
+		return errors.New("auto trim should be used with WithRetain")
+	}
+	if o.arenaSize < 0 {
+		return errors.Errorf("invalid arena size %d, should not be negative", o.arenaSize)
+	}
+	if o.arenaSize > 0 && (!o.retain || o.allocator != nil || o.finalizerSafetyNet) {
+		return errors.New("arena should be used with WithRetain, and can't be used with WithAllocator or WithFinalizerSafetyNet")
+	}
+	if o.finalizerSafetyNet && o.allocator != nil {
+		return errors.New("finalizer safety net can't be used with WithAllocator, the bytes may not be on the heap")
+	}
+	if o.warmRatio < 0 {
+		return errors.Errorf("invalid adaptive warm ratio %v, should not be negative", o.warmRatio)
+	}
+	if o.warmRatio > 0 && !o.retain {
+		return errors.New("adaptive warm should be used with WithRetain")
+	}
+	if o.memoryLimit < 0 {
+		return errors.Errorf("invalid memory limit %d, should not be negative", o.memoryLimit)
+	}
+	if o.memoryLimit > 0 && o.autoTrimInterval == 0 {
+		return errors.New("memory limit should be used with WithAutoTrim")
+	}
+	if o.maxIdle < 0 {
+		return errors.Errorf("invalid max idle %d, should not be negative", o.maxIdle)
+	}
+	if o.slabThreshold < 0 {
+		return errors.Errorf("invalid slab threshold %d, should not be negative", o.slabThreshold)
+	}
+	if o.spillThreshold < 0 {
+		return errors.Errorf("invalid spill threshold %d, should not be negative", o.spillThreshold)
+	}
+	if o.mmapThreshold < 0 {
+		return errors.Errorf("invalid mmap threshold %d, should not be negative", o.mmapThreshold)
+	}
+	if o.profileDepth < 0 || o.profileDepth > maxProfileDepth || o.profileDepth > 0 && o.profileRate < 1 {
+		return errors.Errorf("invalid call site profiling depth %d and sample rate %d, the depth should be in [0, %d] and the rate should be positive",
+			o.profileDepth, o.profileRate, maxProfileDepth)
+	}
+	if o.growthFactor != 0 && (o.growthFactor <= 1 || o.growthFactor > 2) {
+		return errors.Errorf("invalid growth factor %v, should be in (1, 2]", o.growthFactor)
+	}
+	if o.roundUp < 0 {
+		return errors.Errorf("invalid round up granularity %d, should not be negative", o.roundUp)
+	}
+	if o.largeGranularity < 0 {
+		return errors.Errorf("invalid large object granularity %d, should not be negative", o.largeGranularity)
+	}
+	return nil
+}
+
+// tracking returns whether the outstanding allocations should be recorded.
+func (o *options) tracking() bool {
+	return o.leakTracking || o.doubleFreeCheck
+}
+
+// Option is used to control some behavior of BytesPool.
+type Option func(*options)
+
+// WithRegister adds the pool to the package registry, which is returned by RegisteredPools,
+// so the pools of a binary can be enumerated with their names and statistics. The registry keeps the pool alive,
+// Close or Unregister removes it. A Clone of a registered pool is registered as well.
+func WithRegister() Option {
+	return func(o *options) {
+		o.register = true
+	}
+}
+
+// WithLeakTracking records the stack of every allocation until it is freed,
+// the outstanding ones can be inspected by OutstandingAllocations.
+// It is used for debugging, the tracking adds a map and a mutex to Alloc and Free.
+func WithLeakTracking() Option {
+	return func(o *options) {
+		o.leakTracking = true
+	}
+}
+
+// WithDoubleFreeCheck makes Free panic if the origin bytes are freed twice before being allocated again.
+// It shares the outstanding allocations map with leak tracking, so the memory it uses is bounded
+// by the bytes in use, and freeing bytes not allocated by the pool panics as well,
+// like a slice grown by append beyond the bucket, which has a new array.
+// It is used for debugging, Free keeps its fast path when the check is off.
+func WithDoubleFreeCheck() Option {
+	return func(o *options) {
+		o.doubleFreeCheck = true
+	}
+}
+
+// WithReslicedFreeCheck makes Free panic if the origin bytes were resliced to another length,
+// like origin[:100] of a 8K bucket, which would be rejected and leak the bucket bytes silently.
+// Only the bytes whose cap is a bucket size are checked, FreeWithCap is the way to free the resliced bytes.
+func WithReslicedFreeCheck() Option {
+	return func(o *options) {
+		o.reslicedCheck = true
+	}
+}
+
+// WithFinalizerSafetyNet makes the pool set a finalizer on the backing array of every origin it hands out,
+// if an origin is garbage collected without being freed, the finalizer logs a warning with the allocation stack
+// if leak tracking is enabled, and returns the bytes to the pool. The finalizer is cleared by Free,
+// so the bytes freed properly are never resurrected. It is a diagnostic aid, not a substitute for Free:
+// setting and clearing finalizers costs a lot on every allocation, and the finalized arrays survive an extra GC cycle.
+// The buckets backed by mmap and the slab are not covered. The leaks caught are counted by FinalizedLeaks.
+func WithFinalizerSafetyNet() Option {
+	return func(o *options) {
+		o.finalizerSafetyNet = true
+	}
+}
+
+// WithPoisonOnFree makes Free fill the origin bytes with the 0xDEAD pattern before they are pooled,
+// so the code which keeps using the bytes after Free reads obvious garbage instead of the data of the next owner.
+// It only helps to catch use-after-free in tests, it costs a full write of every freed bytes and
+// should not be used in production. Combined with WithDoubleFreeCheck, the bytes freed twice panic before being poisoned.
+func WithPoisonOnFree() Option {
+	return func(o *options) {
+		o.poisonOnFree = true
+	}
+}
+
+// WithBudget limits the size of pooled bytes in use to maxBytes for TryAlloc.
+// Alloc is not limited by the budget, but the bytes it allocates are accounted.
+// Zero means no budget.
+func WithBudget(maxBytes int64) Option {
+	return func(o *options) {
+		o.budget = maxBytes
+	}
+}
+
+// WithLargeObjectPool pools the allocations larger than the max size as well,
+// their sizes are rounded up to a multiple of granularity, so the bytes can be freed and reused.
+// Without it, such allocations are made directly and can't be freed to the pool.
+func WithLargeObjectPool(granularity int) Option {
+	return func(o *options) {
+		o.largeGranularity = granularity
+	}
+}
+
+// WithShards splits the buckets into n shards, Alloc and Free use the shard of the current P.
+// It reduces the contention when bytes are allocated on one goroutine and freed on another.
+func WithShards(n int) Option {
+	return func(o *options) {
+		o.shards = n
+	}
+}
+
+// WithAllocHook sets a hook called by every allocation with the requested size and the bucket index,
+// the index is -1 if the allocation is not pooled, or the number of buckets for the large object pool.
+// The hook runs on the caller's goroutine, so it should be cheap.
+func WithAllocHook(hook func(size, bucket int)) Option {
+	return func(o *options) {
+		o.allocHook = hook
+	}
+}
+
+// WithFreeHook sets a hook called by every Free with the origin bytes and the bucket index as Free returns,
+// the index is -1 if the bytes are not returned to the pool.
+// The hook runs on the caller's goroutine, so it should be cheap.
+func WithFreeHook(hook func(origin []byte, bucket int)) Option {
+	return func(o *options) {
+		o.freeHook = hook
+	}
+}
+
+// WithMmapThreshold backs the buckets whose size is not less than threshold with anonymous mmap,
+// so the pages of freed bytes are released to the OS by madvise(MADV_DONTNEED) instead of staying in the Go heap.
+// The idle mappings are unmapped after GC drops them. The mapped buckets are not sharded.
+// It is only supported on Linux, on other platforms the option is ignored and the bytes are made as usual.
+func WithMmapThreshold(threshold int) Option {
+	return func(o *options) {
+		o.mmapThreshold = threshold
+	}
+}
+
+// WithMaxIdlePerBucket limits the idle bytes held by each bucket to n, Free drops the bytes
+// and returns -1 if the bucket is full, so they are collected by GC instead of being retained until the next GC.
+// The idle count is reset when a bucket misses since sync.Pool drops the idle bytes on GC without notice,
+// it is approximate for sharded pools, whose shards reset it separately.
+// The buckets backed by mmap are not limited, their idle pages are released already.
+func WithMaxIdlePerBucket(n int) Option {
+	return func(o *options) {
+		o.maxIdle = n
+	}
+}
+
+// WithAllocator makes the pool create the bytes of the buckets and the large object pool by allocator instead of make,
+// allocator is called with the full size of the bucket, and it should return exactly that many bytes.
+// It is used to inject bytes prefilled with canaries in tests, or to back the pool with special memory.
+// The allocations larger than the max size which are not pooled are still made as usual.
+func WithAllocator(allocator func(size int) []byte) Option {
+	return func(o *options) {
+		o.allocator = allocator
+	}
+}
+
+// WithRetain makes the buckets hold the idle bytes by mutex protected free lists instead of sync.Pool,
+// so they survive GC, which makes benchmarks deterministic and the steady state predictable.
+// It defeats the automatic memory release of sync.Pool, the idle bytes are only released by Trim, TrimTo or Clear,
+// so it is intended for benchmarks or tightly controlled workloads, and should be used with WithMaxIdlePerBucket
+// to bound the retained memory. The free lists are not sharded, and the large object pool still uses sync.Pool.
+func WithRetain() Option {
+	return func(o *options) {
+		o.retain = true
+	}
+}
+
+// WithHeapBelow makes Alloc make the sizes smaller than minPooledSize on the heap with a nil origin,
+// instead of rounding them up to the smallest bucket, so tiny allocations waste no memory and die young.
+// The caller should still call Free as usual, freeing a nil origin does nothing.
+func WithHeapBelow(minPooledSize int) Option {
+	return func(o *options) {
+		o.heapBelow = minPooledSize
+	}
+}
+
+// WithName names the pool, the name is reported by Stats, String, the outstanding allocations
+// and the metrics, to tell the pools apart in the deployments with multiple pools.
+func WithName(name string) Option {
+	return func(o *options) {
+		o.name = name
+	}
+}
+
+// WithAutoTrim starts a goroutine which trims the idle bytes down to keepBytes by TrimTo every interval.
+// It only works in retain mode, where the retained bytes are under control of the pool.
+// The goroutine references the pool, so the pool should be closed by Close to stop it.
+func WithAutoTrim(interval time.Duration, keepBytes int64) Option {
+	return func(o *options) {
+		o.autoTrimInterval = interval
+		o.autoTrimKeep = keepBytes
+	}
+}
+
+// WithAdaptiveWarm starts a goroutine which learns the allocation rate of every bucket and prefills it every second,
+// keeping targetIdleRatio times the allocations of the last second idle, so the hot buckets are warm again soon
+// after being trimmed, which avoids the latency spikes of creating bytes on the hot path.
+// It only works in retain mode, where the idle bytes are counted. The warmed bytes are reported by AdaptiveWarmStats,
+// and are limited by WithMaxIdlePerBucket. The goroutine references the pool, so the pool should be closed by Close to stop it.
+func WithAdaptiveWarm(targetIdleRatio float64) Option {
+	return func(o *options) {
+		o.warmRatio = targetIdleRatio
+	}
+}
+
+// WithMemoryLimitAware makes the trimmer of WithAutoTrim trim more aggressively under memory pressure,
+// limit is the soft memory limit of the process in bytes. The trimmer keeps keepBytes until the heap
+// reaches half of limit, then keeps less bytes linearly, down to nothing when the heap reaches limit.
+// The heap size is polled by runtime.ReadMemStats on every trim, which stops the world for a short while,
+// so the trim interval should be seconds rather than milliseconds.
+func WithMemoryLimitAware(limit int64) Option {
+	return func(o *options) {
+		o.memoryLimit = limit
+	}
+}
+
+// WithGrowthFactor makes the bucket sizes grow by factor from the base size to the max size instead of doubling,
+// every size is rounded up to a multiple of 64. A finer factor like 1.25 wastes less memory for the sizes
+// just above a power of two, a 5K request gets a 5K bucket instead of 8K, but there are more buckets,
+// so the idle bytes are spread over more buckets and reused less often, and finding the bucket
+// takes a binary search instead of a shift. The factor should be in (1, 2], the powers of two are the default.
+func WithGrowthFactor(factor float64) Option {
+	return func(o *options) {
+		o.growthFactor = factor
+	}
+}
+
+// WithRoundUp makes the pool round the sizes up to a multiple of granularity before selecting the bucket,
+// so the sizes oscillating around a bucket boundary, like 3K and 5K with a granularity of 8K, land in the same bucket
+// and reuse the same warm bytes, at the cost of more waste per allocation.
+// The rounded sizes are capped to the max size, and Capacity and RoundSize return the effective sizes.
+func WithRoundUp(granularity int) Option {
+	return func(o *options) {
+		o.roundUp = granularity
+	}
+}
+
+// WithCallSiteProfiling makes the pool count the allocations by their call sites, the stacks of depth frames,
+// so the biggest consumers of the pool can be found by TopCallSites in production without keeping every
+// outstanding allocation like leak tracking. One of every sampleRate allocations is sampled, which captures
+// the stack and takes a mutex, the others only cost an atomic add. The bucket and large object allocations are profiled.
+func WithCallSiteProfiling(depth, sampleRate int) Option {
+	return func(o *options) {
+		o.profileDepth = depth
+		o.profileRate = sampleRate
+	}
+}
+
+// WithSizeHistogram makes the pool record the distribution of the requested sizes of all the allocations,
+// including the ones larger than the max size, which helps to choose the base size and the max size.
+// It costs an atomic add per allocation. The histogram is returned by RequestSizeHistogram.
+func WithSizeHistogram() Option {
+	return func(o *options) {
+		o.sizeHistogram = true
+	}
+}
+
+// WithDiskSpill makes Alloc back the allocations larger than both threshold and the max size by mapped temp files
+// in dir, or the default temp directory if dir is empty, so the OS can page them out under memory pressure.
+// It trades latency for RSS on huge transient allocations, and takes precedence over the large object pool.
+// The files are removed once mapped, Free unmaps them and returns -1 since they are not pooled.
+// The spilled allocations are neither accounted by the budget nor tracked for leaks.
+// It is only supported on Linux, on other platforms the option is ignored. If a file can't be mapped,
+// the allocation falls back to the heap.
+func WithDiskSpill(threshold int, dir string) Option {
+	return func(o *options) {
+		o.spillThreshold = threshold
+		o.spillDir = dir
+	}
+}
+
+// WithArena reserves reserveBytes at creation, and the new bytes of the buckets are carved from it
+// by a bump allocator until it is used up, then they are created on the heap as usual.
+// The arena holds no pointers, so GC never scans it, and the carved bytes are reused by the free lists
+// instead of being garbage, which lets a hot path run without GC involvement once the buckets are warm.
+// It only works in retain mode, where GC doesn't drop the idle bytes. The carved bytes are never given back
+// to the arena, so trimming them wastes the arena, and the arena is freed with the pool.
+func WithArena(reserveBytes int) Option {
+	return func(o *options) {
+		o.arenaSize = reserveBytes
+	}
+}
+
+// WithSlab makes Alloc serve the sizes not larger than threshold by cells carved from 64KB pages,
+// instead of rounding them up to the base size. The cells are powers of two from 16 bytes, the free cells
+// of a page are tracked by a bitmap, and the page is freed to the pool once all its cells are freed.
+// The threshold is rounded up to a power of two and capped to half of the base size.
+// The pages are allocated, accounted and tracked as usual, the cells are not counted in the statistics,
+// but WithDoubleFreeCheck still catches the cells freed twice.
+func WithSlab(threshold int) Option {
+	return func(o *options) {
+		o.slabThreshold = threshold
+	}
+}
+
+// WithNUMA makes the pool keep a set of buckets per NUMA node, Alloc and Free use the set of the node
+// which the calling thread runs on by getcpu, so the bytes are reused on the node where they were first touched.
+// The buckets backed by mmap of WithMmapThreshold are also bound to the node by mbind when they are allocated,
+// their pages are released on Free, so the new pages are placed on the local node. The bytes on the Go heap
+// can't be bound, they are placed by the Go runtime and the kernel.
+// It is only supported on Linux, on other platforms or machines with a single node it is a plain pool.
+// It can't be used with WithShards.
+func WithNUMA() Option {
+	return func(o *options) {
+		o.numa = true
+	}
+}
@@ -0,0 +1,122 @@
+// Copyright 2017 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bytespool
+
+import (
+	"bytes"
+	"fmt"
+	"runtime"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// maxProfileDepth is the max number of frames recorded for a call site by WithCallSiteProfiling.
+const maxProfileDepth = 64
+
+// CallSite is the aggregated allocations from the same stack recorded by WithCallSiteProfiling.
+type CallSite struct {
+	// Stack is the program counters of the stack, from the caller of the pool.
+	Stack []uintptr
+	// Count is the estimated number of allocations, which is the number of samples multiplied by the sample rate.
+	Count int64
+	// Bytes is the estimated size of the origin bytes allocated.
+	Bytes int64
+}
+
+// String implements fmt.Stringer interface, it prints the estimates and the stack.
+func (s CallSite) String() string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%d allocations of %d bytes at:\n", s.Count, s.Bytes)
+	frames := runtime.CallersFrames(s.Stack)
+	for {
+		frame, more := frames.Next()
+		fmt.Fprintf(&buf, "\t%s\n\t\t%s:%d\n", frame.Function, frame.File, frame.Line)
+		if !more {
+			break
+		}
+	}
+	return buf.String()
+}
+
+// callSiteProfiler counts the sampled allocations by the hash of their stacks.
+type callSiteProfiler struct {
+	// The 64-bit counters are accessed atomically, keep them at the front for alignment.
+	n     int64
+	rate  int64
+	depth int
+
+	mu    sync.Mutex
+	sites map[uint64]*CallSite
+}
+
+func newCallSiteProfiler(depth, rate int) *callSiteProfiler {
+	return &callSiteProfiler{
+		depth: depth,
+		rate:  int64(rate),
+		sites: make(map[uint64]*CallSite),
+	}
+}
+
+// sample records the allocation of size bytes once per rate calls.
+func (p *callSiteProfiler) sample(size, skip int) {
+	if p.rate > 1 && atomic.AddInt64(&p.n, 1)%p.rate != 0 {
+		return
+	}
+	var pcs [maxProfileDepth]uintptr
+	// Skip runtime.Callers, sample and the callers in the pool.
+	n := runtime.Callers(2+skip, pcs[:p.depth])
+	h := hashStack(pcs[:n])
+	p.mu.Lock()
+	s, ok := p.sites[h]
+	if !ok {
+		s = &CallSite{Stack: append([]uintptr(nil), pcs[:n]...)}
+		p.sites[h] = s
+	}
+	s.Count++
+	s.Bytes += int64(size)
+	p.mu.Unlock()
+}
+
+// hashStack returns the FNV-1a hash of the program counters, the collisions are rare enough to be ignored.
+func hashStack(pcs []uintptr) uint64 {
+	h := uint64(14695981039346656037)
+	for _, pc := range pcs {
+		h ^= uint64(pc)
+		h *= 1099511628211
+	}
+	return h
+}
+
+// TopCallSites returns at most n call sites which allocate the most bytes, in descending order.
+// It returns nil if the pool is not created with WithCallSiteProfiling.
+func (bp *BytesPool) TopCallSites(n int) []CallSite {
+	p := bp.profiler
+	if p == nil || n <= 0 {
+		return nil
+	}
+	p.mu.Lock()
+	sites := make([]CallSite, 0, len(p.sites))
+	for _, s := range p.sites {
+		sites = append(sites, CallSite{Stack: s.Stack, Count: s.Count * p.rate, Bytes: s.Bytes * p.rate})
+	}
+	p.mu.Unlock()
+	sort.Slice(sites, func(i, j int) bool {
+		return sites[i].Bytes > sites[j].Bytes
+	})
+	if len(sites) > n {
+		sites = sites[:n]
+	}
+	return sites
+}
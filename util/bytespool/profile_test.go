@@ -0,0 +1,67 @@
+// Copyright 2017 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bytespool
+
+import (
+	. "github.com/pingcap/check"
+)
+
+func allocFromHotSite(bp *BytesPool) {
+	origin, _ := bp.Alloc(4 * kilo)
+	bp.Free(origin)
+}
+
+func allocFromColdSite(bp *BytesPool) {
+	origin, _ := bp.Alloc(kilo)
+	bp.Free(origin)
+}
+
+func (s *testBytesPoolSuite) TestCallSiteProfiling(c *C) {
+	c.Assert(NewBytesPool().TopCallSites(10), IsNil)
+	for _, opt := range []Option{WithCallSiteProfiling(-1, 1), WithCallSiteProfiling(maxProfileDepth+1, 1), WithCallSiteProfiling(8, 0)} {
+		_, err := NewBytesPoolWithOptions(opt)
+		c.Assert(err, NotNil)
+	}
+
+	bp, err := NewBytesPoolWithOptions(WithCallSiteProfiling(2, 1))
+	c.Assert(err, IsNil)
+	for i := 0; i < 10; i++ {
+		allocFromHotSite(bp)
+	}
+	for i := 0; i < 5; i++ {
+		allocFromColdSite(bp)
+	}
+	sites := bp.TopCallSites(10)
+	c.Assert(sites, HasLen, 2)
+	c.Assert(sites[0].Count, Equals, int64(10))
+	c.Assert(sites[0].Bytes, Equals, int64(40*kilo))
+	c.Assert(sites[0].Stack, HasLen, 2)
+	c.Assert(sites[0].String(), Matches, "(?s)10 allocations of 40960 bytes at:\n.*allocFromHotSite.*TestCallSiteProfiling.*")
+	c.Assert(sites[1].Count, Equals, int64(5))
+	c.Assert(sites[1].String(), Matches, "(?s).*allocFromColdSite.*")
+	c.Assert(bp.TopCallSites(1), HasLen, 1)
+
+	// One of every 4 allocations is sampled, and the estimates are scaled back.
+	bp, err = NewBytesPoolWithConfig(kilo, 64*kilo, WithCallSiteProfiling(8, 4), WithLargeObjectPool(kilo))
+	c.Assert(err, IsNil)
+	for i := 0; i < 16; i++ {
+		allocFromHotSite(bp)
+	}
+	origin, _ := bp.Alloc(65 * kilo)
+	bp.Free(origin)
+	sites = bp.TopCallSites(10)
+	c.Assert(sites, HasLen, 1)
+	c.Assert(sites[0].Count, Equals, int64(16))
+	c.Assert(sites[0].Bytes, Equals, int64(64*kilo))
+}
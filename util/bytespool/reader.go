@@ -0,0 +1,129 @@
+// Copyright 2017 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bytespool
+
+import (
+	"io"
+
+	"github.com/juju/errors"
+)
+
+// ErrClosed is returned by reading from a PooledReader or TeeReadCloser after Close,
+// instead of reading the freed bytes which may be allocated by others.
+var ErrClosed = errors.New("bytespool: read after close")
+
+// PooledReader reads the bytes copied into a pooled storage, Close frees the storage.
+// It is not thread-safe.
+type PooledReader struct {
+	pool   *BytesPool
+	origin []byte
+	data   []byte
+	off    int64
+	closed bool
+}
+
+// CopyToPooled copies src into bytes allocated from pool and returns a PooledReader of them,
+// so the caller can drop src and serve the copy until Close. The oversized src is copied to
+// the bytes Alloc makes on the heap, and an empty src allocates nothing.
+func CopyToPooled(pool *BytesPool, src []byte) *PooledReader {
+	origin, data := pool.Alloc(len(src))
+	copy(data, src)
+	return &PooledReader{
+		pool:   pool,
+		origin: origin,
+		data:   data,
+	}
+}
+
+// Read implements io.Reader interface, it returns io.EOF after all the bytes are read.
+func (r *PooledReader) Read(p []byte) (int, error) {
+	if r.closed {
+		return 0, ErrClosed
+	}
+	if r.off >= int64(len(r.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, r.data[r.off:])
+	r.off += int64(n)
+	return n, nil
+}
+
+// WriteTo implements io.WriterTo interface, so io.Copy writes the unread bytes without a buffer.
+// The error of w is returned as is, and io.ErrShortWrite if w writes less without an error, like bytes.Reader.
+func (r *PooledReader) WriteTo(w io.Writer) (int64, error) {
+	if r.closed {
+		return 0, ErrClosed
+	}
+	if r.off >= int64(len(r.data)) {
+		return 0, nil
+	}
+	n, err := w.Write(r.data[r.off:])
+	if n > len(r.data)-int(r.off) {
+		panic("bytespool: invalid Write count")
+	}
+	r.off += int64(n)
+	if err == nil && r.off < int64(len(r.data)) {
+		err = io.ErrShortWrite
+	}
+	return int64(n), err
+}
+
+// Seek implements io.Seeker interface. Seeking beyond the end is allowed and reads io.EOF,
+// seeking to a negative position is an error, and so is seeking after Close.
+func (r *PooledReader) Seek(offset int64, whence int) (int64, error) {
+	if r.closed {
+		return 0, ErrClosed
+	}
+	var abs int64
+	switch whence {
+	case io.SeekStart:
+		abs = offset
+	case io.SeekCurrent:
+		abs = r.off + offset
+	case io.SeekEnd:
+		abs = int64(len(r.data)) + offset
+	default:
+		return 0, errors.Errorf("bytespool: invalid whence %d", whence)
+	}
+	if abs < 0 {
+		return 0, errors.Errorf("bytespool: negative position %d", abs)
+	}
+	r.off = abs
+	return abs, nil
+}
+
+// AsReadSeeker returns r as an io.ReadSeeker for the APIs like http.ServeContent.
+// It is seekable because the bytes are fully in memory, and the caller still owns r,
+// which should be closed to free the bytes once the returned reader is no longer used.
+func (r *PooledReader) AsReadSeeker() io.ReadSeeker {
+	return r
+}
+
+// Len returns the number of the unread bytes.
+func (r *PooledReader) Len() int {
+	if r.off >= int64(len(r.data)) {
+		return 0
+	}
+	return len(r.data) - int(r.off)
+}
+
+// Close frees the storage, reading after Close returns ErrClosed. It is safe to call Close more than once.
+func (r *PooledReader) Close() error {
+	if r.origin != nil {
+		r.pool.Free(r.origin)
+	}
+	r.origin, r.data, r.off = nil, nil, 0
+	r.closed = true
+	return nil
+}
@@ -0,0 +1,173 @@
+// Copyright 2017 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bytespool
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	. "github.com/pingcap/check"
+)
+
+var (
+	_ io.ReadCloser = &PooledReader{}
+	_ io.ReadSeeker = &PooledReader{}
+)
+
+// shortWriter writes at most n bytes at a time without an error.
+type shortWriter struct {
+	n int
+}
+
+func (w shortWriter) Write(p []byte) (int, error) {
+	if len(p) > w.n {
+		return w.n, nil
+	}
+	return len(p), nil
+}
+
+// failWriter fails every write with err.
+type failWriter struct {
+	err error
+}
+
+func (w failWriter) Write(p []byte) (int, error) {
+	return 0, w.err
+}
+
+func (s *testBytesPoolSuite) TestCopyToPooled(c *C) {
+	bp, err := NewBytesPoolWithConfig(kilo, 4*kilo, WithLeakTracking())
+	c.Assert(err, IsNil)
+	defer bp.AssertNoLeaks(c)
+	for _, size := range []int{0, 10, 3 * kilo, 5 * kilo} {
+		src := []byte(strings.Repeat("x", size))
+		r := CopyToPooled(bp, src)
+		// The copy doesn't alias src.
+		if size > 0 {
+			src[0] = 'y'
+		}
+		c.Assert(r.Len(), Equals, size)
+		b, err := ioutil.ReadAll(r)
+		c.Assert(err, IsNil)
+		c.Assert(string(b), Equals, strings.Repeat("x", size))
+		c.Assert(r.Len(), Equals, 0)
+		c.Assert(r.Close(), IsNil)
+		c.Assert(r.Close(), IsNil)
+		n, err := r.Read(make([]byte, 1))
+		c.Assert(n, Equals, 0)
+		c.Assert(err, Equals, ErrClosed)
+		_, err = r.WriteTo(ioutil.Discard)
+		c.Assert(err, Equals, ErrClosed)
+	}
+
+	r := CopyToPooled(bp, []byte("hello"))
+	defer r.Close()
+	buf := make([]byte, 2)
+	n, err := r.Read(buf)
+	c.Assert(err, IsNil)
+	c.Assert(string(buf[:n]), Equals, "he")
+	var out bytes.Buffer
+	m, err := io.Copy(&out, r)
+	c.Assert(err, IsNil)
+	c.Assert(m, Equals, int64(3))
+	c.Assert(out.String(), Equals, "llo")
+}
+
+func (s *testBytesPoolSuite) TestPooledReaderSeek(c *C) {
+	bp := NewBytesPool()
+	r := CopyToPooled(bp, []byte("0123456789"))
+	for _, t := range []struct {
+		offset int64
+		whence int
+		pos    int64
+		read   string
+	}{
+		{2, io.SeekStart, 2, "23"},
+		{-1, io.SeekCurrent, 3, "34"},
+		{-3, io.SeekEnd, 7, "78"},
+		{20, io.SeekStart, 20, ""},
+		{-11, io.SeekCurrent, 9, "9"},
+	} {
+		pos, err := r.Seek(t.offset, t.whence)
+		c.Assert(err, IsNil)
+		c.Assert(pos, Equals, t.pos)
+		buf := make([]byte, 2)
+		n, _ := r.Read(buf)
+		c.Assert(string(buf[:n]), Equals, t.read)
+	}
+	_, err := r.Seek(20, io.SeekStart)
+	c.Assert(err, IsNil)
+	c.Assert(r.Len(), Equals, 0)
+	n, err := r.WriteTo(ioutil.Discard)
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, int64(0))
+	_, err = r.Seek(-1, io.SeekStart)
+	c.Assert(err, NotNil)
+	_, err = r.Seek(0, 3)
+	c.Assert(err, NotNil)
+
+	c.Assert(r.Close(), IsNil)
+	_, err = r.Seek(0, io.SeekStart)
+	c.Assert(err, Equals, ErrClosed)
+}
+
+func (s *testBytesPoolSuite) TestPooledReaderServeContent(c *C) {
+	bp, err := NewBytesPoolWithConfig(kilo, 4*kilo, WithLeakTracking())
+	c.Assert(err, IsNil)
+	defer bp.AssertNoLeaks(c)
+	content := strings.Repeat("0123456789", 300)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		r := CopyToPooled(bp, []byte(content))
+		defer r.Close()
+		http.ServeContent(w, req, "content.txt", time.Time{}, r.AsReadSeeker())
+	})
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/content.txt", nil))
+	c.Assert(rec.Code, Equals, http.StatusOK)
+	c.Assert(rec.Header().Get("Content-Length"), Equals, "3000")
+	c.Assert(rec.Body.String(), Equals, content)
+
+	// The range requests seek the reader.
+	req := httptest.NewRequest("GET", "/content.txt", nil)
+	req.Header.Set("Range", "bytes=2995-")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	c.Assert(rec.Code, Equals, http.StatusPartialContent)
+	c.Assert(rec.Body.String(), Equals, "56789")
+}
+
+func (s *testBytesPoolSuite) TestPooledReaderWriteToError(c *C) {
+	bp := NewBytesPool()
+	r := CopyToPooled(bp, []byte("0123456789"))
+	defer r.Close()
+	n, err := r.WriteTo(shortWriter{4})
+	c.Assert(err, Equals, io.ErrShortWrite)
+	c.Assert(n, Equals, int64(4))
+	c.Assert(r.Len(), Equals, 6)
+
+	werr := errors.New("write error")
+	_, err = r.WriteTo(failWriter{werr})
+	c.Assert(err, Equals, werr)
+	_, err = r.Read(make([]byte, 10))
+	c.Assert(err, IsNil)
+	_, err = r.Read(make([]byte, 10))
+	c.Assert(err, Equals, io.EOF)
+}
@@ -0,0 +1,70 @@
+// Copyright 2017 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bytespool
+
+import (
+	"github.com/juju/errors"
+)
+
+// RecordIterator iterates a buffer of fixed-size records without copying, it is a value type,
+// so iterating costs no allocation. The usage is:
+//
+//	it, err := Records(data, 16, false)
+//	for it.Next() {
+//		rec := it.Record()
+//	}
+type RecordIterator struct {
+	data []byte
+	size int
+	off  int
+}
+
+// Records returns a RecordIterator over data split into records of recordSize bytes.
+// A trailing partial record is skipped if skipPartial is true, otherwise it is an error.
+func Records(data []byte, recordSize int, skipPartial bool) (RecordIterator, error) {
+	if recordSize <= 0 {
+		return RecordIterator{}, errors.Errorf("invalid record size %d, should be positive", recordSize)
+	}
+	if r := len(data) % recordSize; r != 0 {
+		if !skipPartial {
+			return RecordIterator{}, errors.Errorf("data length %d is not a multiple of record size %d", len(data), recordSize)
+		}
+		data = data[:len(data)-r]
+	}
+	return RecordIterator{data: data, size: recordSize, off: -recordSize}, nil
+}
+
+// Next moves to the next record, it returns false after the last one.
+func (it *RecordIterator) Next() bool {
+	if it.off+it.size >= len(it.data) {
+		it.off = len(it.data)
+		return false
+	}
+	it.off += it.size
+	return true
+}
+
+// Record returns the current record, it aliases data, and its capacity is limited to the record,
+// so appending to it never overwrites the next record.
+func (it *RecordIterator) Record() []byte {
+	return it.data[it.off : it.off+it.size : it.off+it.size]
+}
+
+// Len returns the number of records in total.
+func (it *RecordIterator) Len() int {
+	if it.size == 0 {
+		return 0
+	}
+	return len(it.data) / it.size
+}
@@ -0,0 +1,69 @@
+// Copyright 2017 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bytespool
+
+import (
+	"testing"
+
+	. "github.com/pingcap/check"
+)
+
+func (s *testBytesPoolSuite) TestRecords(c *C) {
+	_, err := Records(nil, 0, true)
+	c.Assert(err, NotNil)
+	_, err = Records([]byte("abcde"), 2, false)
+	c.Assert(err, ErrorMatches, "data length 5 is not a multiple of record size 2")
+
+	for _, t := range []struct {
+		data        string
+		skipPartial bool
+		records     []string
+	}{
+		{"", false, nil},
+		{"abcdef", false, []string{"ab", "cd", "ef"}},
+		{"abcdef", true, []string{"ab", "cd", "ef"}},
+		{"abcdefg", true, []string{"ab", "cd", "ef"}},
+		{"a", true, nil},
+	} {
+		it, err := Records([]byte(t.data), 2, t.skipPartial)
+		c.Assert(err, IsNil)
+		c.Assert(it.Len(), Equals, len(t.records))
+		var records []string
+		for it.Next() {
+			rec := it.Record()
+			c.Assert(cap(rec), Equals, 2)
+			records = append(records, string(rec))
+		}
+		c.Assert(records, DeepEquals, t.records, Commentf("data %q", t.data))
+		c.Assert(it.Next(), IsFalse)
+	}
+	var it RecordIterator
+	c.Assert(it.Next(), IsFalse)
+	c.Assert(it.Len(), Equals, 0)
+}
+
+func (s *testBytesPoolSuite) TestRecordsNoAlloc(c *C) {
+	bp := NewBytesPool()
+	origin, data := bp.Alloc(16 * kilo)
+	defer bp.Free(origin)
+	var sum int
+	allocs := testing.AllocsPerRun(10, func() {
+		it, _ := Records(data, 16, false)
+		for it.Next() {
+			sum += len(it.Record())
+		}
+	})
+	c.Assert(allocs, Equals, float64(0))
+	c.Assert(sum, Equals, 11*16*kilo)
+}
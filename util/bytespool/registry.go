@@ -0,0 +1,56 @@
+// Copyright 2017 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bytespool
+
+import (
+	"sync"
+)
+
+// registry holds the pools created with WithRegister in the creation order.
+var registry struct {
+	sync.Mutex
+	pools []*BytesPool
+}
+
+func register(bp *BytesPool) {
+	registry.Lock()
+	registry.pools = append(registry.pools, bp)
+	registry.Unlock()
+}
+
+// Unregister removes the pool from RegisteredPools, it is called by Close.
+// The registry references the registered pools, so a pool created with WithRegister is never garbage collected
+// until it is closed or unregistered. It does nothing if the pool is not registered.
+func (bp *BytesPool) Unregister() {
+	registry.Lock()
+	for i, p := range registry.pools {
+		if p == bp {
+			copy(registry.pools[i:], registry.pools[i+1:])
+			registry.pools[len(registry.pools)-1] = nil
+			registry.pools = registry.pools[:len(registry.pools)-1]
+			break
+		}
+	}
+	registry.Unlock()
+}
+
+// RegisteredPools returns the pools created with WithRegister and not unregistered yet, in the creation order,
+// so a debug endpoint can dump the statistics of every pool from one place.
+func RegisteredPools() []*BytesPool {
+	registry.Lock()
+	pools := make([]*BytesPool, len(registry.pools))
+	copy(pools, registry.pools)
+	registry.Unlock()
+	return pools
+}
@@ -0,0 +1,67 @@
+// Copyright 2017 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bytespool
+
+import (
+	"fmt"
+	"sync"
+
+	. "github.com/pingcap/check"
+)
+
+func (s *testBytesPoolSuite) TestRegistry(c *C) {
+	NewBytesPool()
+	c.Assert(RegisteredPools(), HasLen, 0)
+
+	a, err := NewBytesPoolWithOptions(WithName("a"), WithRegister())
+	c.Assert(err, IsNil)
+	b, err := NewBytesPoolWithOptions(WithName("b"), WithRegister())
+	c.Assert(err, IsNil)
+	clone := a.Clone()
+	pools := RegisteredPools()
+	c.Assert(pools, HasLen, 3)
+	c.Assert(pools[0], Equals, a)
+	c.Assert(pools[1], Equals, b)
+	c.Assert(pools[2], Equals, clone)
+
+	c.Assert(a.Close(), IsNil)
+	b.Unregister()
+	b.Unregister()
+	c.Assert(RegisteredPools(), DeepEquals, []*BytesPool{clone})
+	clone.Unregister()
+	c.Assert(RegisteredPools(), HasLen, 0)
+}
+
+func (s *testBytesPoolSuite) TestRegistryConcurrent(c *C) {
+	var wg sync.WaitGroup
+	pools := make([]*BytesPool, 16)
+	for i := range pools {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			pools[i], _ = NewBytesPoolWithOptions(WithName(fmt.Sprintf("p%d", i)), WithRegister())
+		}(i)
+	}
+	wg.Wait()
+	c.Assert(RegisteredPools(), HasLen, len(pools))
+	for _, bp := range pools {
+		wg.Add(1)
+		go func(bp *BytesPool) {
+			defer wg.Done()
+			bp.Close()
+		}(bp)
+	}
+	wg.Wait()
+	c.Assert(RegisteredPools(), HasLen, 0)
+}
@@ -0,0 +1,195 @@
+// Copyright 2017 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bytespool
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// freeList is a stack of idle bytes used by the buckets in retain mode, GC doesn't clear it.
+type freeList struct {
+	mu    sync.Mutex
+	items [][]byte
+}
+
+func (l *freeList) push(b []byte) {
+	l.mu.Lock()
+	l.items = append(l.items, b)
+	l.mu.Unlock()
+}
+
+// pop returns nil if the list is empty.
+func (l *freeList) pop() []byte {
+	l.mu.Lock()
+	var b []byte
+	if n := len(l.items); n > 0 {
+		b = l.items[n-1]
+		l.items[n-1] = nil
+		l.items = l.items[:n-1]
+	}
+	l.mu.Unlock()
+	return b
+}
+
+func (l *freeList) len() int {
+	l.mu.Lock()
+	n := len(l.items)
+	l.mu.Unlock()
+	return n
+}
+
+func (l *freeList) clear() {
+	l.mu.Lock()
+	l.items = nil
+	l.mu.Unlock()
+}
+
+// getIdle gets idle bytes from the i-th bucket, it returns nil if the bucket is empty.
+func (bp *BytesPool) getIdle(i int) []byte {
+	if bp.retained != nil {
+		return bp.retained[i].pop()
+	}
+	if v := bp.bucket(i).Get(); v != nil {
+		return v.([]byte)
+	}
+	return nil
+}
+
+func (bp *BytesPool) putIdle(i int, origin []byte) {
+	if bp.retained != nil {
+		bp.retained[i].push(origin)
+		return
+	}
+	bp.bucket(i).Put(origin)
+}
+
+// TrimTo drops the idle bytes of the buckets, starting from the largest bucket,
+// until the retained bytes are not more than targetBytes, and returns the number of bytes released.
+// It only works in retain mode, since the bytes held by sync.Pool can't be counted,
+// otherwise it does nothing and returns 0, use Trim or Clear instead.
+func (bp *BytesPool) TrimTo(targetBytes int64) int64 {
+	if bp.retained == nil {
+		return 0
+	}
+	var retained int64
+	for i := range bp.retained {
+		retained += int64(bp.retained[i].len()) * int64(bp.bucketSize(i))
+	}
+	var released int64
+	for i := len(bp.retained) - 1; i >= 0 && retained > targetBytes; i-- {
+		size := int64(bp.bucketSize(i))
+		for retained > targetBytes && bp.retained[i].pop() != nil {
+			if bp.opts.maxIdle > 0 {
+				atomic.AddInt64(&bp.counters[i].idle, -1)
+			}
+			retained -= size
+			released += size
+		}
+	}
+	return released
+}
+
+// AutoTrimStats is the statistics of the background trimmer of WithAutoTrim.
+type AutoTrimStats struct {
+	// Runs is the number of trims done.
+	Runs int64
+	// LastTime is when the last trim is done.
+	LastTime time.Time
+	// LastKeep is the number of bytes kept by the last trim, it is lower than the configured one
+	// under memory pressure if WithMemoryLimitAware is used.
+	LastKeep int64
+	// LastReleased is the number of bytes released by the last trim.
+	LastReleased int64
+	// TotalReleased is the number of bytes released by all the trims.
+	TotalReleased int64
+}
+
+type autoTrimmer struct {
+	stopCh chan struct{}
+	doneCh chan struct{}
+
+	mu    sync.Mutex
+	stats AutoTrimStats
+}
+
+func newAutoTrimmer() *autoTrimmer {
+	return &autoTrimmer{
+		stopCh: make(chan struct{}),
+		doneCh: make(chan struct{}),
+	}
+}
+
+func (t *autoTrimmer) run(bp *BytesPool, interval time.Duration, keepBytes, memoryLimit int64) {
+	defer close(t.doneCh)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			keep := keepBytes
+			if memoryLimit > 0 {
+				keep = pressureKeep(keepBytes, heapInUse(), memoryLimit)
+			}
+			released := bp.TrimTo(keep)
+			t.mu.Lock()
+			t.stats.Runs++
+			t.stats.LastTime = time.Now()
+			t.stats.LastKeep = keep
+			t.stats.LastReleased = released
+			t.stats.TotalReleased += released
+			t.mu.Unlock()
+		case <-t.stopCh:
+			return
+		}
+	}
+}
+
+// heapInUse returns the bytes of the allocated heap objects, it is a variable to be mocked in tests.
+var heapInUse = func() int64 {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	return int64(ms.HeapAlloc)
+}
+
+// pressureKeep scales keepBytes down linearly from heap at half of limit to heap at limit.
+func pressureKeep(keepBytes, heap, limit int64) int64 {
+	half := limit / 2
+	switch {
+	case heap <= half:
+		return keepBytes
+	case heap >= limit:
+		return 0
+	}
+	return int64(float64(keepBytes) * float64(limit-heap) / float64(limit-half))
+}
+
+// stop stops the goroutine and waits for it to exit.
+func (t *autoTrimmer) stop() {
+	close(t.stopCh)
+	<-t.doneCh
+}
+
+// AutoTrimStats returns the statistics of the background trimmer, it is zero if WithAutoTrim is not used.
+func (bp *BytesPool) AutoTrimStats() AutoTrimStats {
+	if bp.trimmer == nil {
+		return AutoTrimStats{}
+	}
+	bp.trimmer.mu.Lock()
+	st := bp.trimmer.stats
+	bp.trimmer.mu.Unlock()
+	return st
+}
@@ -0,0 +1,149 @@
+// Copyright 2017 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bytespool
+
+import (
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/pingcap/check"
+)
+
+func (s *testBytesPoolSuite) TestRetain(c *C) {
+	bp, err := NewBytesPoolWithOptions(WithRetain(), WithMaxIdlePerBucket(2))
+	c.Assert(err, IsNil)
+	origins := make([][]byte, 3)
+	for i := range origins {
+		origins[i], _ = bp.Alloc(kilo)
+	}
+	c.Assert(bp.Free(origins[0]), Equals, 0)
+	c.Assert(bp.Free(origins[1]), Equals, 0)
+	c.Assert(bp.Free(origins[2]), Equals, -1)
+
+	// The idle bytes survive GC.
+	runtime.GC()
+	runtime.GC()
+	origin, _ := bp.Alloc(kilo)
+	c.Assert(&origin[0], Equals, &origins[1][0])
+	origin, _ = bp.Alloc(kilo)
+	c.Assert(&origin[0], Equals, &origins[0][0])
+	bp.Alloc(kilo)
+	st := bp.Stats()
+	c.Assert(st.Gets, Equals, int64(6))
+	c.Assert(st.Misses, Equals, int64(4))
+
+	bp.Prefill(kilo, 3)
+	c.Assert(bp.retained[0].items, HasLen, 2)
+	bp.Trim()
+	c.Assert(bp.retained[0].items, HasLen, 0)
+	bp.Prefill(kilo, 1)
+	bp.Clear()
+	c.Assert(bp.retained[0].items, HasLen, 0)
+	c.Assert(bp.counters[0].idle, Equals, int64(0))
+}
+
+func BenchmarkRetain(b *testing.B) {
+	bp, _ := NewBytesPoolWithOptions(WithRetain(), WithMaxIdlePerBucket(16))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		origin, _ := bp.Alloc(64 * kilo)
+		bp.Free(origin)
+	}
+}
+
+func (s *testBytesPoolSuite) TestTrimTo(c *C) {
+	c.Assert(NewBytesPool().TrimTo(0), Equals, int64(0))
+
+	bp, err := NewBytesPoolWithOptions(WithRetain(), WithMaxIdlePerBucket(4))
+	c.Assert(err, IsNil)
+	bp.Prefill(kilo, 4)
+	bp.Prefill(4*kilo, 2)
+	bp.Prefill(16*kilo, 1)
+	c.Assert(bp.TrimTo(100*kilo), Equals, int64(0))
+	// Drops the 16K one and one of the 4K ones.
+	c.Assert(bp.TrimTo(10*kilo), Equals, int64(20*kilo))
+	c.Assert(bp.retained[4].len(), Equals, 0)
+	c.Assert(bp.retained[2].len(), Equals, 1)
+	c.Assert(bp.retained[0].len(), Equals, 4)
+	c.Assert(bp.counters[2].idle, Equals, int64(1))
+	c.Assert(bp.TrimTo(0), Equals, int64(8*kilo))
+	c.Assert(bp.TrimTo(0), Equals, int64(0))
+}
+
+func (s *testBytesPoolSuite) TestAutoTrim(c *C) {
+	_, err := NewBytesPoolWithOptions(WithAutoTrim(time.Millisecond, 0))
+	c.Assert(err, NotNil)
+	_, err = NewBytesPoolWithOptions(WithRetain(), WithAutoTrim(-1, 0))
+	c.Assert(err, NotNil)
+	c.Assert(NewBytesPool().AutoTrimStats(), Equals, AutoTrimStats{})
+
+	bp, err := NewBytesPoolWithOptions(WithRetain(), WithAutoTrim(time.Millisecond, 4*kilo))
+	c.Assert(err, IsNil)
+	bp.Prefill(4*kilo, 3)
+	for i := 0; i < 1000 && bp.AutoTrimStats().TotalReleased < 8*kilo; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	st := bp.AutoTrimStats()
+	c.Assert(st.TotalReleased, Equals, int64(8*kilo))
+	c.Assert(st.Runs > 0, IsTrue)
+	c.Assert(st.LastTime.IsZero(), IsFalse)
+	c.Assert(bp.retained[2].len(), Equals, 1)
+
+	// Close stops the trimmer.
+	c.Assert(bp.Close(), IsNil)
+	runs := bp.AutoTrimStats().Runs
+	time.Sleep(5 * time.Millisecond)
+	c.Assert(bp.AutoTrimStats().Runs, Equals, runs)
+}
+
+func (s *testBytesPoolSuite) TestPressureKeep(c *C) {
+	c.Assert(pressureKeep(mega, 0, 100*mega), Equals, int64(mega))
+	c.Assert(pressureKeep(mega, 50*mega, 100*mega), Equals, int64(mega))
+	c.Assert(pressureKeep(mega, 75*mega, 100*mega), Equals, int64(mega/2))
+	c.Assert(pressureKeep(mega, 90*mega, 100*mega), Equals, int64(mega/5))
+	c.Assert(pressureKeep(mega, 100*mega, 100*mega), Equals, int64(0))
+	c.Assert(pressureKeep(mega, 200*mega, 100*mega), Equals, int64(0))
+}
+
+func (s *testBytesPoolSuite) TestMemoryLimitAware(c *C) {
+	_, err := NewBytesPoolWithOptions(WithMemoryLimitAware(mega))
+	c.Assert(err, NotNil)
+	_, err = NewBytesPoolWithOptions(WithRetain(), WithAutoTrim(time.Millisecond, 0), WithMemoryLimitAware(-1))
+	c.Assert(err, NotNil)
+
+	var heap int64 = 10 * mega
+	defer func(fn func() int64) { heapInUse = fn }(heapInUse)
+	heapInUse = func() int64 { return atomic.LoadInt64(&heap) }
+
+	bp, err := NewBytesPoolWithOptions(WithRetain(), WithAutoTrim(time.Millisecond, 16*kilo),
+		WithMemoryLimitAware(16*mega))
+	c.Assert(err, IsNil)
+	defer bp.Close()
+	bp.Prefill(4*kilo, 4)
+	for i := 0; i < 1000 && bp.AutoTrimStats().Runs == 0; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	c.Assert(bp.AutoTrimStats().LastKeep, Equals, int64(12*kilo))
+	c.Assert(bp.AutoTrimStats().TotalReleased, Equals, int64(4*kilo))
+
+	// The heap reaches the limit, all the idle bytes are trimmed.
+	atomic.StoreInt64(&heap, 16*mega)
+	for i := 0; i < 1000 && bp.AutoTrimStats().TotalReleased < 16*kilo; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	c.Assert(bp.AutoTrimStats().LastKeep, Equals, int64(0))
+	c.Assert(bp.AutoTrimStats().TotalReleased, Equals, int64(16*kilo))
+}
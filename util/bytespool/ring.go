@@ -0,0 +1,116 @@
+// Copyright 2017 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bytespool
+
+import (
+	"io"
+	"math/bits"
+
+	"github.com/juju/errors"
+)
+
+// ErrRingFull is returned by Ring.Write if the ring has no room for all the bytes.
+var ErrRingFull = errors.New("bytespool: ring is full")
+
+// Ring is a bounded FIFO buffer of bytes whose storage is allocated from a BytesPool.
+// Its capacity is a power of two, so the positions wrap around by masking.
+// It is not thread-safe, and it should be closed to return the storage to the pool.
+type Ring struct {
+	pool   *BytesPool
+	origin []byte
+	buf    []byte
+	mask   uint
+	// r and w are the total number of bytes read and written, their difference is the length.
+	r, w uint
+}
+
+// NewRing creates a Ring whose capacity is size rounded up to a power of two.
+func NewRing(pool *BytesPool, size int) *Ring {
+	if size < 1 {
+		size = 1
+	}
+	n := 1 << uint(bits.Len(uint(size-1)))
+	origin, data := pool.Alloc(n)
+	return &Ring{
+		pool:   pool,
+		origin: origin,
+		buf:    data,
+		mask:   uint(n - 1),
+	}
+}
+
+// Len returns the number of unread bytes.
+func (r *Ring) Len() int {
+	return int(r.w - r.r)
+}
+
+// Cap returns the capacity of the ring.
+func (r *Ring) Cap() int {
+	return len(r.buf)
+}
+
+// Available returns the number of bytes which can be written without reading.
+func (r *Ring) Available() int {
+	return r.Cap() - r.Len()
+}
+
+// Write implements io.Writer interface, it writes as many bytes as fit and returns ErrRingFull if not all of p fit.
+func (r *Ring) Write(p []byte) (int, error) {
+	n := len(p)
+	if avail := r.Available(); n > avail {
+		n = avail
+	}
+	start := int(r.w & r.mask)
+	m := copy(r.buf[start:], p[:n])
+	copy(r.buf, p[m:n])
+	r.w += uint(n)
+	if n < len(p) {
+		return n, ErrRingFull
+	}
+	return n, nil
+}
+
+// Read implements io.Reader interface, it returns io.EOF if the ring is empty.
+func (r *Ring) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	n := r.Len()
+	if n == 0 {
+		return 0, io.EOF
+	}
+	if n > len(p) {
+		n = len(p)
+	}
+	start := int(r.r & r.mask)
+	m := copy(p[:n], r.buf[start:])
+	copy(p[m:n], r.buf)
+	r.r += uint(n)
+	return n, nil
+}
+
+// Reset discards all the unread bytes.
+func (r *Ring) Reset() {
+	r.r, r.w = 0, 0
+}
+
+// Close returns the storage to the pool, the ring should not be used after Close.
+func (r *Ring) Close() error {
+	if r.origin != nil {
+		r.pool.Free(r.origin)
+	}
+	r.origin, r.buf = nil, nil
+	r.r, r.w, r.mask = 0, 0, 0
+	return nil
+}
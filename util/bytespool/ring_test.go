@@ -0,0 +1,72 @@
+// Copyright 2017 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bytespool
+
+import (
+	"bytes"
+	"io"
+
+	. "github.com/pingcap/check"
+)
+
+var _ io.ReadWriteCloser = &Ring{}
+
+func (s *testBytesPoolSuite) TestRing(c *C) {
+	bp, err := NewBytesPoolWithOptions(WithLeakTracking())
+	c.Assert(err, IsNil)
+	r := NewRing(bp, 1000)
+	c.Assert(r.Cap(), Equals, kilo)
+	c.Assert(r.Len(), Equals, 0)
+	c.Assert(r.Available(), Equals, kilo)
+	buf := make([]byte, kilo)
+	n, err := r.Read(buf)
+	c.Assert(n, Equals, 0)
+	c.Assert(err, Equals, io.EOF)
+
+	// Write and read across the end of the storage.
+	var written, read bytes.Buffer
+	for i := 0; i < 100; i++ {
+		p := bytes.Repeat([]byte{byte(i)}, 300)
+		n, err = r.Write(p)
+		c.Assert(err, IsNil)
+		c.Assert(n, Equals, 300)
+		written.Write(p)
+		n, err = r.Read(buf[:250])
+		c.Assert(err, IsNil)
+		read.Write(buf[:n])
+		if r.Len() > 500 {
+			n, _ = r.Read(buf)
+			read.Write(buf[:n])
+		}
+	}
+	n, _ = r.Read(buf)
+	read.Write(buf[:n])
+	c.Assert(r.Len(), Equals, 0)
+	c.Assert(read.Bytes(), DeepEquals, written.Bytes())
+
+	// Short write.
+	n, err = r.Write(make([]byte, 2*kilo))
+	c.Assert(n, Equals, kilo)
+	c.Assert(err, Equals, ErrRingFull)
+	c.Assert(r.Available(), Equals, 0)
+	r.Reset()
+	c.Assert(r.Len(), Equals, 0)
+
+	c.Assert(r.Close(), IsNil)
+	c.Assert(bp.OutstandingAllocations(), HasLen, 0)
+
+	r = NewRing(bp, 0)
+	c.Assert(r.Cap(), Equals, 1)
+	c.Assert(r.Close(), IsNil)
+}
@@ -0,0 +1,93 @@
+// Copyright 2017 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bytespool
+
+import (
+	"sync"
+)
+
+// Scope records the bytes allocated by it and frees them all at once by Release,
+// so a request handler can just defer Release instead of freeing every allocation.
+// Alloc can be called concurrently, like by the goroutines of an errgroup sharing the scope,
+// but Release should only be called after all of them are finished.
+type Scope struct {
+	pool *BytesPool
+
+	mu      sync.Mutex
+	origins [][]byte
+}
+
+var scopes = sync.Pool{
+	New: func() interface{} { return &Scope{origins: make([][]byte, 0, 8)} },
+}
+
+// NewScope gets a Scope of the pool, it is pooled as well, so a scope costs no garbage in the steady state.
+func (bp *BytesPool) NewScope() *Scope {
+	s := scopes.Get().(*Scope)
+	s.pool = bp
+	return s
+}
+
+// Alloc allocates size bytes from the pool, they are valid until Release.
+func (s *Scope) Alloc(size int) []byte {
+	origin, data := s.pool.alloc(size, 1)
+	if origin != nil {
+		s.mu.Lock()
+		s.origins = append(s.origins, origin)
+		s.mu.Unlock()
+	}
+	return data
+}
+
+// ScopeMark marks the allocations of a Scope made before Scope.Checkpoint.
+type ScopeMark struct {
+	n int
+}
+
+// Checkpoint returns a mark of the allocations made so far, RollbackTo frees the ones made after it.
+// The checkpoints can be nested, like the backtracking points of a recursive descent parser.
+func (s *Scope) Checkpoint() ScopeMark {
+	s.mu.Lock()
+	n := len(s.origins)
+	s.mu.Unlock()
+	return ScopeMark{n: n}
+}
+
+// RollbackTo frees the bytes allocated after the checkpoint in the reverse order, the bytes allocated before it
+// are still valid. The checkpoints made after mark are invalidated, rolling back to them panics.
+// With the concurrent Alloc calls, the bytes allocated by the other goroutines after the checkpoint are freed as well.
+func (s *Scope) RollbackTo(mark ScopeMark) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if mark.n > len(s.origins) {
+		panic("bytespool: roll back to an invalidated checkpoint")
+	}
+	for i := len(s.origins) - 1; i >= mark.n; i-- {
+		s.pool.Free(s.origins[i])
+		s.origins[i] = nil
+	}
+	s.origins = s.origins[:mark.n]
+}
+
+// Release frees all the bytes allocated by the scope and puts the scope back,
+// neither the bytes nor the scope should be used after Release.
+func (s *Scope) Release() {
+	for i, origin := range s.origins {
+		s.pool.Free(origin)
+		s.origins[i] = nil
+	}
+	s.origins = s.origins[:0]
+	s.pool = nil
+	scopes.Put(s)
+}
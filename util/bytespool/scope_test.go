@@ -0,0 +1,105 @@
+// Copyright 2017 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bytespool
+
+import (
+	"fmt"
+
+	. "github.com/pingcap/check"
+	goctx "golang.org/x/net/context"
+	"golang.org/x/sync/errgroup"
+)
+
+func (s *testBytesPoolSuite) TestScope(c *C) {
+	bp, err := NewBytesPoolWithConfig(kilo, 4*kilo, WithLeakTracking())
+	c.Assert(err, IsNil)
+	func() {
+		scope := bp.NewScope()
+		defer scope.Release()
+		for i := 0; i < 20; i++ {
+			c.Assert(scope.Alloc(100), HasLen, 100)
+		}
+		c.Assert(scope.Alloc(3*kilo), HasLen, 3*kilo)
+		c.Assert(scope.Alloc(0), HasLen, 0)
+		// Oversized bytes are not recorded.
+		c.Assert(scope.Alloc(5*kilo), HasLen, 5*kilo)
+		c.Assert(bp.OutstandingAllocations(), HasLen, 21)
+	}()
+	c.Assert(bp.OutstandingAllocations(), HasLen, 0)
+	st := bp.Stats()
+	c.Assert(st.Frees, Equals, int64(21))
+	c.Assert(st.FreeRejections, Equals, int64(0))
+
+	scope := bp.NewScope()
+	c.Assert(scope.origins, HasLen, 0)
+	scope.Release()
+}
+
+func (s *testBytesPoolSuite) TestScopeCheckpoint(c *C) {
+	bp, err := NewBytesPoolWithConfig(kilo, 4*kilo, WithLeakTracking())
+	c.Assert(err, IsNil)
+	defer bp.AssertNoLeaks(c)
+	scope := bp.NewScope()
+	defer scope.Release()
+
+	kept := scope.Alloc(kilo)
+	kept[0] = 'k'
+	outer := scope.Checkpoint()
+	scope.Alloc(kilo)
+	scope.Alloc(5 * kilo)
+	inner := scope.Checkpoint()
+	scope.Alloc(2 * kilo)
+	scope.Alloc(3 * kilo)
+	c.Assert(bp.OutstandingAllocations(), HasLen, 4)
+
+	scope.RollbackTo(inner)
+	c.Assert(bp.OutstandingAllocations(), HasLen, 2)
+	// Rolling back to the same checkpoint again is a no-op.
+	scope.RollbackTo(inner)
+	c.Assert(bp.OutstandingAllocations(), HasLen, 2)
+
+	scope.Alloc(kilo)
+	scope.RollbackTo(outer)
+	c.Assert(bp.OutstandingAllocations(), HasLen, 1)
+	c.Assert(kept[0], Equals, byte('k'))
+	c.Assert(func() { scope.RollbackTo(inner) }, PanicMatches, "bytespool: roll back to an invalidated checkpoint")
+
+	c.Assert(bp.Stats().FreeRejections, Equals, int64(0))
+}
+
+func (s *testBytesPoolSuite) TestScopeErrgroup(c *C) {
+	bp, err := NewBytesPoolWithConfig(kilo, 4*kilo, WithLeakTracking())
+	c.Assert(err, IsNil)
+	scope := bp.NewScope()
+	g, ctx := errgroup.WithContext(goctx.Background())
+	for i := 0; i < 8; i++ {
+		i := i
+		g.Go(func() error {
+			for j := 0; j < 100; j++ {
+				if ctx.Err() != nil {
+					return nil
+				}
+				scope.Alloc(100 + j)
+			}
+			if i == 7 {
+				return fmt.Errorf("task %d failed", i)
+			}
+			return nil
+		})
+	}
+	c.Assert(g.Wait(), ErrorMatches, "task 7 failed")
+	c.Assert(bp.OutstandingAllocations(), HasLen, len(scope.origins))
+	scope.Release()
+	c.Assert(bp.OutstandingAllocations(), HasLen, 0)
+}
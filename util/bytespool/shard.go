@@ -0,0 +1,42 @@
+// Copyright 2017 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bytespool
+
+import (
+	"runtime"
+	_ "unsafe" // required by go:linkname
+)
+
+//go:linkname runtimeProcPin runtime.procPin
+func runtimeProcPin() int
+
+//go:linkname runtimeProcUnpin runtime.procUnpin
+func runtimeProcUnpin()
+
+// localShard returns the shard index of the current P.
+func localShard(shards int) int {
+	pid := runtimeProcPin()
+	runtimeProcUnpin()
+	return pid % shards
+}
+
+// NewShardedBytesPool creates a new bytes pool with the default sizes, whose buckets are split into shards.
+// Alloc and Free prefer the shard of the current P, bytes freed on a different P from where they were
+// allocated just go to the local shard. If shards is 0, GOMAXPROCS is used.
+func NewShardedBytesPool(shards int, opts ...Option) (*BytesPool, error) {
+	if shards == 0 {
+		shards = runtime.GOMAXPROCS(0)
+	}
+	return NewBytesPoolWithOptions(append(opts, WithShards(shards))...)
+}
@@ -0,0 +1 @@
+// The empty assembly file allows the body-less function declarations used by go:linkname.
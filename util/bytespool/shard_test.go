@@ -0,0 +1,93 @@
+// Copyright 2017 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bytespool
+
+import (
+	"runtime"
+	"sync"
+	"testing"
+
+	. "github.com/pingcap/check"
+)
+
+func (s *testBytesPoolSuite) TestShardedBytesPool(c *C) {
+	_, err := NewShardedBytesPool(-1)
+	c.Assert(err, NotNil)
+
+	bp, err := NewShardedBytesPool(0)
+	c.Assert(err, IsNil)
+	c.Assert(bp.loadShards(), HasLen, runtime.GOMAXPROCS(0))
+
+	bp, err = NewShardedBytesPool(4, WithDoubleFreeCheck())
+	c.Assert(err, IsNil)
+	c.Assert(bp.loadShards(), HasLen, 4)
+	c.Assert(NewBytesPool().loadShards(), HasLen, 1)
+	ch := make(chan []byte, 100)
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 1; i <= 1000; i++ {
+			origin, _ := bp.Alloc(i * 10)
+			ch <- origin
+		}
+		close(ch)
+	}()
+	var mismatches int
+	go func() {
+		defer wg.Done()
+		for origin := range ch {
+			if bp.Free(origin) != bp.bucketIdx(len(origin)) {
+				mismatches++
+			}
+		}
+	}()
+	wg.Wait()
+	c.Assert(mismatches, Equals, 0)
+	st := bp.Stats()
+	c.Assert(st.Gets, Equals, int64(1000))
+	c.Assert(st.Frees, Equals, int64(1000))
+}
+
+func benchmarkCrossGoroutine(b *testing.B, bp *BytesPool) {
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		ch := make(chan []byte, 16)
+		done := make(chan struct{})
+		go func() {
+			for origin := range ch {
+				bp.Free(origin)
+			}
+			close(done)
+		}()
+		for pb.Next() {
+			origin, _ := bp.Alloc(4 * kilo)
+			ch <- origin
+		}
+		close(ch)
+		<-done
+	})
+}
+
+func BenchmarkCrossGoroutine(b *testing.B) {
+	benchmarkCrossGoroutine(b, NewBytesPool())
+}
+
+func BenchmarkShardedCrossGoroutine(b *testing.B) {
+	bp, err := NewShardedBytesPool(0)
+	if err != nil {
+		b.Fatal(err)
+	}
+	benchmarkCrossGoroutine(b, bp)
+}
@@ -0,0 +1,184 @@
+// Copyright 2017 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bytespool
+
+import (
+	"math/bits"
+	"sync"
+)
+
+const (
+	minSlabCell     = 16
+	defaultSlabPage = 64 * kilo
+)
+
+// slab carves the pages allocated from the pool into cells for the small allocations.
+// Every power of two cell size from minSlabCell to the threshold has its own class.
+type slab struct {
+	pool      *BytesPool
+	threshold int
+	pageSize  int
+	classes   []slabClass
+}
+
+type slabClass struct {
+	cell int
+
+	mu sync.Mutex
+	// partial is the pages which have free cells.
+	partial []*slabPage
+	// cells maps the cells in use to their pages.
+	cells map[uintptr]*slabPage
+}
+
+type slabPage struct {
+	// origin is the page bytes to free to the pool, it is nil if the pool made data without pooling it,
+	// like the sizes below WithHeapBelow or the fallback of a failed mmap, then the page is left to GC.
+	origin []byte
+	// data is the bytes the cells are carved from.
+	data []byte
+	// free is the bitmap of the free cells.
+	free      []uint64
+	numFree   int
+	inPartial bool
+}
+
+// newSlab returns nil if the pool can't hold the slab.
+func newSlab(pool *BytesPool, threshold int) *slab {
+	// The cells must be smaller than the base size, so they can't be mistaken for the buckets by Free.
+	if threshold >= pool.baseSize {
+		threshold = pool.baseSize / 2
+	}
+	if threshold < minSlabCell {
+		return nil
+	}
+	threshold = 1 << uint(bits.Len(uint(threshold-1)))
+	pageSize := defaultSlabPage
+	if pageSize > pool.maxSize {
+		pageSize = pool.maxSize
+	}
+	s := &slab{pool: pool, threshold: threshold, pageSize: pageSize}
+	for cell := minSlabCell; cell <= threshold; cell *= 2 {
+		s.classes = append(s.classes, slabClass{cell: cell, cells: make(map[uintptr]*slabPage)})
+	}
+	return s
+}
+
+func (s *slab) classIdx(size int) int {
+	if size <= minSlabCell {
+		return 0
+	}
+	return bits.Len(uint(size-1)) - bits.Len(uint(minSlabCell-1))
+}
+
+// alloc carves a cell for size bytes, skip is the number of its callers in the pool.
+func (s *slab) alloc(size, skip int) (origin, data []byte) {
+	c := &s.classes[s.classIdx(size)]
+	c.mu.Lock()
+	for len(c.partial) == 0 {
+		// Alloc runs the hooks of the pool, so the page is allocated without holding the lock.
+		c.mu.Unlock()
+		p := s.newPage(c.cell, skip+1)
+		c.mu.Lock()
+		c.partial = append(c.partial, p)
+	}
+	p := c.partial[len(c.partial)-1]
+	j := 0
+	for p.free[j] == 0 {
+		j++
+	}
+	b := bits.TrailingZeros64(p.free[j])
+	p.free[j] &^= 1 << uint(b)
+	p.numFree--
+	if p.numFree == 0 {
+		c.partial[len(c.partial)-1] = nil
+		c.partial = c.partial[:len(c.partial)-1]
+		p.inPartial = false
+	}
+	off := (j*64 + b) * c.cell
+	origin = p.data[off : off+c.cell : off+c.cell]
+	c.cells[bytesPointer(origin)] = p
+	c.mu.Unlock()
+	return origin, origin[:size]
+}
+
+func (s *slab) newPage(cell, skip int) *slabPage {
+	origin, data := s.pool.alloc(s.pageSize, skip+1)
+	n := s.pageSize / cell
+	p := &slabPage{
+		origin:    origin,
+		data:      data,
+		free:      make([]uint64, (n+63)/64),
+		numFree:   n,
+		inPartial: true,
+	}
+	for i := 0; i < n; i++ {
+		p.free[i/64] |= 1 << uint(i%64)
+	}
+	return p
+}
+
+// free returns the cell to its page, it returns false if origin is not a cell in use.
+// The page is freed to the pool once all its cells are free.
+func (s *slab) free(origin []byte) bool {
+	n := len(origin)
+	if !s.isCellSize(n) {
+		return false
+	}
+	c := &s.classes[s.classIdx(n)]
+	ptr := bytesPointer(origin)
+	c.mu.Lock()
+	p, ok := c.cells[ptr]
+	if !ok {
+		c.mu.Unlock()
+		return false
+	}
+	delete(c.cells, ptr)
+	i := int(ptr-bytesPointer(p.data)) / c.cell
+	p.free[i/64] |= 1 << uint(i%64)
+	p.numFree++
+	var empty *slabPage
+	if p.numFree == s.pageSize/c.cell {
+		if p.inPartial {
+			c.removePartial(p)
+		}
+		empty = p
+	} else if !p.inPartial {
+		c.partial = append(c.partial, p)
+		p.inPartial = true
+	}
+	c.mu.Unlock()
+	if empty != nil && empty.origin != nil {
+		s.pool.Free(empty.origin)
+	}
+	return true
+}
+
+// isCellSize returns whether n is the size of a cell class.
+func (s *slab) isCellSize(n int) bool {
+	return n >= minSlabCell && n <= s.threshold && isPowerOfTwo(n)
+}
+
+func (c *slabClass) removePartial(p *slabPage) {
+	for i, q := range c.partial {
+		if q == p {
+			last := len(c.partial) - 1
+			c.partial[i] = c.partial[last]
+			c.partial[last] = nil
+			c.partial = c.partial[:last]
+			break
+		}
+	}
+	p.inPartial = false
+}
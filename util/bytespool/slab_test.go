@@ -0,0 +1,148 @@
+// Copyright 2017 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bytespool
+
+import (
+	"errors"
+	"math/rand"
+
+	. "github.com/pingcap/check"
+)
+
+func (s *testBytesPoolSuite) TestSlab(c *C) {
+	_, err := NewBytesPoolWithOptions(WithSlab(-1))
+	c.Assert(err, NotNil)
+
+	bp, err := NewBytesPoolWithOptions(WithSlab(300), WithLeakTracking())
+	c.Assert(err, IsNil)
+	c.Assert(bp.slab.threshold, Equals, 512)
+	c.Assert(bp.slab.classes, HasLen, 6)
+	c.Assert(bp.Capacity(10), Equals, 16)
+	c.Assert(bp.Capacity(100), Equals, 128)
+	c.Assert(bp.Capacity(513), Equals, kilo)
+
+	origin, data := bp.Alloc(100)
+	c.Assert(origin, HasLen, 128)
+	c.Assert(cap(origin), Equals, 128)
+	c.Assert(data, HasLen, 100)
+	// The page is allocated from the pool.
+	c.Assert(bp.OutstandingAllocations(), HasLen, 1)
+	c.Assert(bp.OutstandingAllocations()[0].Size, Equals, 64*kilo)
+	c.Assert(bp.Free(origin), Equals, 0)
+	// The empty page is freed.
+	c.Assert(bp.OutstandingAllocations(), HasLen, 0)
+	c.Assert(bp.Free(origin), Equals, -1)
+	c.Assert(bp.Free(make([]byte, 128)), Equals, -1)
+
+	// Fill more than a page of cells, the cells never overlap.
+	n := 64*kilo/64 + 10
+	origins := make([][]byte, n)
+	for i := range origins {
+		origins[i], data = bp.Alloc(33)
+		for j := range data {
+			data[j] = byte(i)
+		}
+	}
+	c.Assert(bp.OutstandingAllocations(), HasLen, 2)
+	for i, origin := range origins {
+		for _, b := range origin[:33] {
+			c.Assert(b, Equals, byte(i))
+		}
+	}
+	for _, i := range rand.Perm(n) {
+		c.Assert(bp.Free(origins[i]), Equals, 0)
+	}
+	c.Assert(bp.OutstandingAllocations(), HasLen, 0)
+	c.Assert(bp.Stats().FreeRejections, Equals, int64(2))
+
+	// The slab can't be smaller than the min cell.
+	bp, err = NewBytesPoolWithOptions(WithSlab(8))
+	c.Assert(err, IsNil)
+	c.Assert(bp.slab, IsNil)
+}
+
+func (s *testBytesPoolSuite) TestSlabCapped(c *C) {
+	bp, err := NewBytesPoolWithConfig(kilo, 4*kilo, WithSlab(4*kilo))
+	c.Assert(err, IsNil)
+	c.Assert(bp.slab.threshold, Equals, 512)
+	c.Assert(bp.slab.pageSize, Equals, 4*kilo)
+	origins, _ := bp.AllocMany([]int{500, 500, 600})
+	c.Assert(origins[0], HasLen, 512)
+	c.Assert(origins[2], HasLen, kilo)
+	c.Assert(bp.FreeMany(origins), Equals, 3)
+}
+
+func (s *testBytesPoolSuite) TestSlabDoubleFree(c *C) {
+	bp, err := NewBytesPoolWithOptions(WithSlab(512), WithDoubleFreeCheck())
+	c.Assert(err, IsNil)
+	origin, _ := bp.Alloc(100)
+	other, _ := bp.Alloc(100)
+	c.Assert(bp.Free(origin), Equals, 0)
+	c.Assert(func() { bp.Free(origin) }, PanicMatches, "bytespool: free 128 bytes at .* which are already freed or not allocated by the pool")
+	c.Assert(func() { bp.Free(make([]byte, 64)) }, PanicMatches, "bytespool: free 64 bytes .*")
+	c.Assert(bp.Free(other), Equals, 0)
+}
+
+func (s *testBytesPoolSuite) TestSlabUnpooledPage(c *C) {
+	if !mmapSupported {
+		c.Skip("mmap is not supported")
+	}
+	// The pages made when mmap fails are not pooled, the cells are still carved from them.
+	mmapAnon = func(int) ([]byte, error) {
+		return nil, errors.New("mmap error")
+	}
+	defer func() {
+		mmapAnon = mmapBytes
+	}()
+	var allocs int
+	bp, err := NewBytesPoolWithOptions(WithSlab(512), WithMmapThreshold(64*kilo), WithLeakTracking(),
+		WithAllocHook(func(size, i int) {
+			c.Assert(i, Equals, -1)
+			allocs++
+		}))
+	c.Assert(err, IsNil)
+	origins := make([][]byte, 64*kilo/kilo*2+1)
+	for i := range origins {
+		var data []byte
+		origins[i], data = bp.Alloc(500)
+		c.Assert(origins[i], HasLen, 512)
+		data[0] = byte(i)
+	}
+	c.Assert(allocs, Equals, 2)
+	for i, origin := range origins {
+		c.Assert(origin[0], Equals, byte(i))
+	}
+	for _, origin := range origins {
+		c.Assert(bp.Free(origin), Equals, 0)
+	}
+	c.Assert(bp.OutstandingAllocations(), HasLen, 0)
+}
+
+func (s *testBytesPoolSuite) TestSlabAllocHook(c *C) {
+	// The page is allocated without the lock of the class, so the hook can allocate from the slab.
+	var bp *BytesPool
+	var nested []byte
+	bp, err := NewBytesPoolWithOptions(WithSlab(512), WithAllocHook(func(size, i int) {
+		if size == 64*kilo && nested == nil {
+			// The nested Alloc makes its own page, the hook runs again for it.
+			nested = []byte{}
+			nested, _ = bp.Alloc(100)
+		}
+	}))
+	c.Assert(err, IsNil)
+	origin, _ := bp.Alloc(100)
+	c.Assert(nested, HasLen, 128)
+	c.Assert(bp.Free(origin), Equals, 0)
+	c.Assert(bp.Free(nested), Equals, 0)
+}
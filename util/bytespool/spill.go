@@ -0,0 +1,73 @@
+// Copyright 2017 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bytespool
+
+import (
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// spillTier maps the huge allocations to temp files, the mappings in use are kept to be unmapped by Free.
+type spillTier struct {
+	threshold int
+	dir       string
+
+	mu    sync.Mutex
+	inUse map[uintptr][]byte
+}
+
+func newSpillTier(threshold int, dir string) *spillTier {
+	return &spillTier{
+		threshold: threshold,
+		dir:       dir,
+		inUse:     make(map[uintptr][]byte),
+	}
+}
+
+// alloc returns nil if the file can't be mapped.
+func (t *spillTier) alloc(size int) []byte {
+	b, err := mmapFile(t.dir, size)
+	if err != nil {
+		log.Warnf("bytespool: spill %d bytes to disk failed: %v", size, err)
+		return nil
+	}
+	t.mu.Lock()
+	t.inUse[bytesPointer(b)] = b
+	t.mu.Unlock()
+	return b
+}
+
+func (t *spillTier) owns(origin []byte) bool {
+	t.mu.Lock()
+	_, ok := t.inUse[bytesPointer(origin)]
+	t.mu.Unlock()
+	return ok
+}
+
+// free unmaps origin, it returns false if origin is not mapped by the tier.
+func (t *spillTier) free(origin []byte) bool {
+	ptr := bytesPointer(origin)
+	t.mu.Lock()
+	b, ok := t.inUse[ptr]
+	delete(t.inUse, ptr)
+	t.mu.Unlock()
+	if !ok {
+		return false
+	}
+	if err := munmapBytes(b); err != nil {
+		log.Warnf("bytespool: unmap %d spilled bytes failed: %v", len(b), err)
+	}
+	return true
+}
@@ -0,0 +1,63 @@
+// Copyright 2017 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bytespool
+
+import (
+	"io/ioutil"
+	"os"
+
+	. "github.com/pingcap/check"
+)
+
+func (s *testBytesPoolSuite) TestDiskSpill(c *C) {
+	_, err := NewBytesPoolWithOptions(WithDiskSpill(-1, ""))
+	c.Assert(err, NotNil)
+
+	dir, err := ioutil.TempDir("", "bytespool_test")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+	bp, err := NewBytesPoolWithConfig(kilo, 4*kilo, WithDiskSpill(8*kilo, dir), WithLargeObjectPool(4*kilo))
+	c.Assert(err, IsNil)
+	if !mmapSupported {
+		c.Assert(bp.spills, IsNil)
+		return
+	}
+
+	// Not larger than the threshold, it goes to the large object pool.
+	origin, _ := bp.Alloc(6 * kilo)
+	c.Assert(len(origin), Equals, 8*kilo)
+	c.Assert(bp.Free(origin), Equals, bp.numBuckets)
+
+	origin, data := bp.Alloc(10*kilo + 1)
+	c.Assert(len(origin), Equals, 10*kilo+1)
+	c.Assert(len(data), Equals, 10*kilo+1)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	c.Assert(data[kilo], Equals, byte(0))
+	// The file is removed once mapped.
+	files, err := ioutil.ReadDir(dir)
+	c.Assert(err, IsNil)
+	c.Assert(files, HasLen, 0)
+	c.Assert(bp.Free(origin), Equals, -1)
+	c.Assert(bp.spills.inUse, HasLen, 0)
+	c.Assert(bp.Stats().FreeRejections, Equals, int64(0))
+
+	// Falls back to the heap if the file can't be created.
+	bp, err = NewBytesPoolWithConfig(kilo, 4*kilo, WithDiskSpill(8*kilo, dir+"/not_exist"))
+	c.Assert(err, IsNil)
+	origin, data = bp.Alloc(10 * kilo)
+	c.Assert(origin, IsNil)
+	c.Assert(len(data), Equals, 10*kilo)
+}
@@ -0,0 +1,262 @@
+// Copyright 2017 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bytespool
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/bits"
+	"sync/atomic"
+
+	"github.com/juju/errors"
+)
+
+// bucketCounter holds the counters of a bucket, all the fields are accessed atomically.
+type bucketCounter struct {
+	gets   int64
+	misses int64
+	frees  int64
+	// live is the number of bytes allocated from the bucket and not freed yet, it is not reset by ResetStats.
+	live int64
+	// idle is the number of bytes held by the bucket, it is only counted if WithMaxIdlePerBucket is used.
+	idle int64
+}
+
+// BucketStats is the statistics of a bucket.
+type BucketStats struct {
+	// Size is the size of bytes held by the bucket.
+	Size int `json:"size"`
+	// Gets is the number of allocations served by the bucket.
+	Gets int64 `json:"gets"`
+	// Misses is the number of allocations which found the bucket empty and created new bytes.
+	Misses int64 `json:"misses"`
+	// Frees is the number of bytes returned to the bucket.
+	Frees int64 `json:"frees"`
+	// Live is the number of bytes allocated from the bucket and not freed yet.
+	Live int64 `json:"live"`
+}
+
+// Hits returns the number of allocations which reused bytes in the bucket.
+func (s BucketStats) Hits() int64 {
+	return s.Gets - s.Misses
+}
+
+// MarshalJSON implements json.Marshaler interface, it adds the hits and the live bytes to the fields.
+func (s BucketStats) MarshalJSON() ([]byte, error) {
+	type bucketStats BucketStats
+	b, err := json.Marshal(struct {
+		bucketStats
+		Hits      int64 `json:"hits"`
+		LiveBytes int64 `json:"live_bytes"`
+	}{bucketStats(s), s.Hits(), int64(s.Size) * s.Live})
+	return b, errors.Trace(err)
+}
+
+// Stats is the statistics of a BytesPool.
+type Stats struct {
+	// Name is the name of the pool.
+	Name string `json:"name,omitempty"`
+	// Buckets is the statistics of every bucket, ordered by size.
+	Buckets []BucketStats `json:"buckets"`
+	// Gets, Misses and Frees are the totals of all the buckets.
+	Gets   int64 `json:"gets"`
+	Misses int64 `json:"misses"`
+	Frees  int64 `json:"frees"`
+	// FreeRejections is the number of Free calls which did not return the bytes to the pool.
+	FreeRejections int64 `json:"free_rejections"`
+	// Oversized is the number of allocations larger than the max size, they bypass the pool.
+	Oversized int64 `json:"oversized"`
+	// PeakLiveBytes is the high-water mark of the live bytes, it is only accounted for pools with a budget.
+	PeakLiveBytes int64 `json:"peak_live_bytes"`
+	// FragmentationBytes is the bytes wasted by rounding the requested sizes, it is only accounted with WithSizeHistogram.
+	FragmentationBytes int64 `json:"fragmentation_bytes"`
+	// HoldDurations is the distribution of how long the freed bytes were held,
+	// it is only recorded for pools with leak tracking or the double free check, otherwise it is nil.
+	HoldDurations HoldDurationHistogram `json:"hold_durations,omitempty"`
+}
+
+// Hits returns the total number of allocations which reused bytes in the pool.
+func (s Stats) Hits() int64 {
+	return s.Gets - s.Misses
+}
+
+// MarshalJSON implements json.Marshaler interface, it adds the hits and the live bytes to the fields,
+// so the statistics can be dumped by an admin endpoint directly.
+func (s Stats) MarshalJSON() ([]byte, error) {
+	type stats Stats
+	b, err := json.Marshal(struct {
+		stats
+		Hits      int64 `json:"hits"`
+		LiveBytes int64 `json:"live_bytes"`
+	}{stats(s), s.Hits(), s.LiveBytes()})
+	return b, errors.Trace(err)
+}
+
+// LiveBytes returns the total bytes allocated from the buckets and not freed yet.
+func (s Stats) LiveBytes() int64 {
+	var n int64
+	for _, b := range s.Buckets {
+		n += int64(b.Size) * b.Live
+	}
+	return n
+}
+
+// Stats returns the statistics of the pool.
+// It is safe to call it concurrently with Alloc and Free,
+// the counters are loaded one by one, so the result is not an atomic snapshot.
+func (bp *BytesPool) Stats() Stats {
+	st := Stats{
+		Name:               bp.opts.name,
+		Buckets:            make([]BucketStats, len(bp.counters)),
+		FreeRejections:     atomic.LoadInt64(&bp.freeRejections),
+		Oversized:          atomic.LoadInt64(&bp.oversized),
+		PeakLiveBytes:      atomic.LoadInt64(&bp.peakLiveBytes),
+		FragmentationBytes: atomic.LoadInt64(&bp.fragmentation),
+	}
+	for i := range bp.counters {
+		cnt := &bp.counters[i]
+		bs := BucketStats{
+			Size:   bp.bucketSize(i),
+			Gets:   atomic.LoadInt64(&cnt.gets),
+			Misses: atomic.LoadInt64(&cnt.misses),
+			Frees:  atomic.LoadInt64(&cnt.frees),
+			Live:   atomic.LoadInt64(&cnt.live),
+		}
+		st.Buckets[i] = bs
+		st.Gets += bs.Gets
+		st.Misses += bs.Misses
+		st.Frees += bs.Frees
+	}
+	if bp.tracker != nil {
+		st.HoldDurations = bp.tracker.holdDurations()
+	}
+	return st
+}
+
+// healthCheckMinGets is the min number of allocations of a bucket to be judged by HealthCheck,
+// the colder buckets miss by nature.
+const healthCheckMinGets = 100
+
+// HealthCheck returns an error naming the buckets whose miss ratio, the misses divided by the gets, exceeds maxMissRatio,
+// which means the pool is undersized or churning. Only the buckets with at least 100 allocations are checked,
+// and the ratios are cumulative since the pool is created or ResetStats is called. It can back a health endpoint.
+func (bp *BytesPool) HealthCheck(maxMissRatio float64) error {
+	if maxMissRatio < 0 || maxMissRatio > 1 {
+		return errors.Errorf("invalid max miss ratio %v, should be in [0, 1]", maxMissRatio)
+	}
+	st := bp.Stats()
+	var buf bytes.Buffer
+	for _, b := range st.Buckets {
+		if b.Gets < healthCheckMinGets {
+			continue
+		}
+		if ratio := float64(b.Misses) / float64(b.Gets); ratio > maxMissRatio {
+			if buf.Len() > 0 {
+				buf.WriteString(", ")
+			}
+			fmt.Fprintf(&buf, "%d: %.2f", b.Size, ratio)
+		}
+	}
+	if buf.Len() == 0 {
+		return nil
+	}
+	if st.Name != "" {
+		return errors.Errorf("bytespool: the miss ratios of the buckets of pool %s exceed %.2f, %s", st.Name, maxMissRatio, buf.String())
+	}
+	return errors.Errorf("bytespool: the miss ratios of the buckets exceed %.2f, %s", maxMissRatio, buf.String())
+}
+
+// ResetStats zeroes the counters of the statistics, so the rates can be computed from the snapshots
+// taken before every reset. The gauges, like the live bytes of the buckets and LiveBytes, are kept accurate.
+// The counters are zeroed one by one, the allocations and frees done concurrently may be counted before or after it.
+// It is independent of Clear, which drops the idle bytes but keeps the statistics.
+func (bp *BytesPool) ResetStats() {
+	for i := range bp.counters {
+		cnt := &bp.counters[i]
+		atomic.StoreInt64(&cnt.gets, 0)
+		atomic.StoreInt64(&cnt.misses, 0)
+		atomic.StoreInt64(&cnt.frees, 0)
+	}
+	atomic.StoreInt64(&bp.freeRejections, 0)
+	atomic.StoreInt64(&bp.oversized, 0)
+	atomic.StoreInt64(&bp.fragmentation, 0)
+	for i := range bp.sizes {
+		atomic.StoreInt64(&bp.sizes[i], 0)
+	}
+	if bp.tracker != nil {
+		bp.tracker.Lock()
+		for i := range bp.tracker.holds {
+			bp.tracker.holds[i] = 0
+		}
+		bp.tracker.Unlock()
+	}
+}
+
+// recordSize adds size to the histogram of the requested sizes.
+func (bp *BytesPool) recordSize(size int) {
+	if size < 0 {
+		return
+	}
+	atomic.AddInt64(&bp.sizes[bits.Len(uint(size))], 1)
+	if size > 0 {
+		atomic.AddInt64(&bp.fragmentation, int64(bp.Capacity(size)-size))
+	}
+}
+
+// FragmentationBytes returns the total bytes wasted by rounding the requested sizes up to the bucket sizes,
+// the sum of Capacity(size)-size over the allocations since the pool is created or ResetStats is called.
+// It quantifies the cost of the bucket configuration, like whether WithGrowthFactor is worth it.
+// It is only accounted with WithSizeHistogram, otherwise it returns 0.
+func (bp *BytesPool) FragmentationBytes() int64 {
+	return atomic.LoadInt64(&bp.fragmentation)
+}
+
+// RequestSizeHistogram returns the histogram of the requested sizes recorded by WithSizeHistogram, or nil without it.
+// The i-th element counts the sizes in [1<<(i-1), 1<<i), and the 0-th element counts the size 0.
+func (bp *BytesPool) RequestSizeHistogram() []int64 {
+	if bp.sizes == nil {
+		return nil
+	}
+	h := make([]int64, len(bp.sizes))
+	for i := range h {
+		h[i] = atomic.LoadInt64(&bp.sizes[i])
+	}
+	return h
+}
+
+// String implements fmt.Stringer interface, it prints the configuration of the pool
+// and the statistics of the buckets which have been used, one bucket per line.
+func (bp *BytesPool) String() string {
+	st := bp.Stats()
+	var buf bytes.Buffer
+	buf.WriteString("BytesPool{")
+	if st.Name != "" {
+		fmt.Fprintf(&buf, "name: %s, ", st.Name)
+	}
+	fmt.Fprintf(&buf, "base: %d, buckets: %d, max: %d, oversized: %d, free rejections: %d}",
+		bp.baseSize, bp.numBuckets, bp.maxSize, st.Oversized, st.FreeRejections)
+	for _, b := range st.Buckets {
+		if b.Gets == 0 && b.Frees == 0 && b.Live == 0 {
+			continue
+		}
+		var ratio float64
+		if b.Gets > 0 {
+			ratio = float64(b.Hits()) / float64(b.Gets)
+		}
+		fmt.Fprintf(&buf, "\n  %d: live %d, hits %d, misses %d, hit ratio %.2f",
+			b.Size, b.Live, b.Hits(), b.Misses, ratio)
+	}
+	return buf.String()
+}
@@ -0,0 +1,233 @@
+// Copyright 2017 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bytespool
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/bits"
+	"strings"
+	"sync"
+
+	. "github.com/pingcap/check"
+)
+
+func (s *testBytesPoolSuite) TestStats(c *C) {
+	bp, err := NewBytesPoolWithConfig(kilo, 4*kilo)
+	c.Assert(err, IsNil)
+	origin, _ := bp.Alloc(100)
+	bp.Free(origin)
+	origin, _ = bp.Alloc(kilo)
+	bp.Free(origin)
+	origin, _ = bp.Alloc(3 * kilo)
+	bp.Free(origin)
+	bp.Alloc(5 * kilo)
+	bp.Free(make([]byte, 100))
+
+	st := bp.Stats()
+	c.Assert(st.Buckets, HasLen, 3)
+	c.Assert(st.Buckets[0].Size, Equals, kilo)
+	c.Assert(st.Buckets[0].Gets, Equals, int64(2))
+	c.Assert(st.Buckets[0].Frees, Equals, int64(2))
+	c.Assert(st.Buckets[0].Misses+st.Buckets[0].Hits(), Equals, int64(2))
+	c.Assert(st.Buckets[1].Gets, Equals, int64(0))
+	c.Assert(st.Buckets[2].Size, Equals, 4*kilo)
+	c.Assert(st.Buckets[2].Gets, Equals, int64(1))
+	c.Assert(st.Buckets[2].Misses, Equals, int64(1))
+	c.Assert(st.Gets, Equals, int64(3))
+	c.Assert(st.Frees, Equals, int64(3))
+	c.Assert(st.Hits(), Equals, st.Gets-st.Misses)
+	c.Assert(st.FreeRejections, Equals, int64(1))
+	c.Assert(st.Oversized, Equals, int64(1))
+}
+
+func (s *testBytesPoolSuite) TestStatsConcurrent(c *C) {
+	bp := NewBytesPool()
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				origin, _ := bp.Alloc((j + 1) * 100)
+				bp.Stats()
+				bp.Free(origin)
+			}
+		}()
+	}
+	wg.Wait()
+	st := bp.Stats()
+	c.Assert(st.Gets, Equals, int64(800))
+	c.Assert(st.Frees, Equals, int64(800))
+}
+
+func (s *testBytesPoolSuite) TestStatsJSON(c *C) {
+	bp, err := NewBytesPoolWithConfig(kilo, 2*kilo, WithName("json"))
+	c.Assert(err, IsNil)
+	origin, _ := bp.Alloc(kilo)
+	bp.Free(origin)
+	bp.Alloc(2 * kilo)
+	bp.Free(make([]byte, 10))
+	b, err := json.Marshal(bp.Stats())
+	c.Assert(err, IsNil)
+	c.Assert(string(b), Equals, `{"name":"json","buckets":[`+
+		`{"size":1024,"gets":1,"misses":1,"frees":1,"live":0,"hits":0,"live_bytes":0},`+
+		`{"size":2048,"gets":1,"misses":1,"frees":0,"live":1,"hits":0,"live_bytes":2048}],`+
+		`"gets":2,"misses":2,"frees":1,"free_rejections":1,"oversized":0,"peak_live_bytes":0,"fragmentation_bytes":0,"hits":0,"live_bytes":2048}`)
+
+	var st Stats
+	c.Assert(json.Unmarshal(b, &st), IsNil)
+	c.Assert(st, DeepEquals, bp.Stats())
+}
+
+func (s *testBytesPoolSuite) TestString(c *C) {
+	bp, err := NewBytesPoolWithConfig(kilo, 4*kilo)
+	c.Assert(err, IsNil)
+	c.Assert(fmt.Sprintf("%v", bp), Equals, "BytesPool{base: 1024, buckets: 3, max: 4096, oversized: 0, free rejections: 0}")
+	bp.Alloc(3 * kilo)
+	bp.Alloc(5 * kilo)
+	c.Assert(bp.String(), Equals, "BytesPool{base: 1024, buckets: 3, max: 4096, oversized: 1, free rejections: 0}\n"+
+		"  4096: live 1, hits 0, misses 1, hit ratio 0.00")
+}
+
+func (s *testBytesPoolSuite) TestName(c *C) {
+	bp := NewBytesPool()
+	c.Assert(bp.Name(), Equals, "")
+	c.Assert(strings.HasPrefix(bp.String(), "BytesPool{base: "), IsTrue)
+
+	bp, err := NewBytesPoolWithOptions(WithName("network"), WithLeakTracking())
+	c.Assert(err, IsNil)
+	c.Assert(bp.Name(), Equals, "network")
+	c.Assert(bp.Stats().Name, Equals, "network")
+	c.Assert(strings.HasPrefix(bp.String(), "BytesPool{name: network, base: "), IsTrue)
+	bp.Alloc(kilo)
+	allocs := bp.OutstandingAllocations()
+	c.Assert(allocs, HasLen, 1)
+	c.Assert(allocs[0].Pool, Equals, "network")
+	c.Assert(strings.HasPrefix(allocs[0].String(), "1024 bytes allocated from pool network at:"), IsTrue)
+}
+
+func (s *testBytesPoolSuite) TestRequestSizeHistogram(c *C) {
+	c.Assert(NewBytesPool().RequestSizeHistogram(), IsNil)
+
+	bp, err := NewBytesPoolWithConfig(kilo, 4*kilo, WithSizeHistogram(), WithBudget(mega))
+	c.Assert(err, IsNil)
+	bp.Alloc(0)
+	bp.Alloc(1)
+	bp.Alloc(kilo - 1)
+	bp.Alloc(kilo)
+	bp.TryAlloc(kilo + 1)
+	bp.AllocMany([]int{100, 5 * kilo})
+	h := bp.RequestSizeHistogram()
+	c.Assert(h, HasLen, bits.UintSize+1)
+	c.Assert(h[0], Equals, int64(1))
+	c.Assert(h[1], Equals, int64(1))
+	c.Assert(h[7], Equals, int64(1))
+	c.Assert(h[10], Equals, int64(1))
+	c.Assert(h[11], Equals, int64(2))
+	c.Assert(h[13], Equals, int64(1))
+	var total int64
+	for _, n := range h {
+		total += n
+	}
+	c.Assert(total, Equals, int64(7))
+}
+
+func (s *testBytesPoolSuite) TestFragmentationBytes(c *C) {
+	bp := NewBytesPool()
+	bp.Alloc(5 * kilo)
+	c.Assert(bp.FragmentationBytes(), Equals, int64(0))
+
+	bp, err := NewBytesPoolWithConfig(kilo, 16*kilo, WithSizeHistogram())
+	c.Assert(err, IsNil)
+	bp.Alloc(0)
+	bp.Alloc(100)
+	bp.Alloc(kilo)
+	bp.TryAlloc(5 * kilo)
+	bp.AllocMany([]int{3 * kilo, 20 * kilo})
+	expect := int64(kilo - 100 + 3*kilo + kilo)
+	c.Assert(bp.FragmentationBytes(), Equals, expect)
+	c.Assert(bp.Stats().FragmentationBytes, Equals, expect)
+	bp.ResetStats()
+	c.Assert(bp.FragmentationBytes(), Equals, int64(0))
+
+	// Finer buckets waste less for the same workload.
+	bp, err = NewBytesPoolWithConfig(kilo, 16*kilo, WithSizeHistogram(), WithGrowthFactor(1.25))
+	c.Assert(err, IsNil)
+	bp.Alloc(5 * kilo)
+	c.Assert(bp.FragmentationBytes(), Equals, int64(bp.Capacity(5*kilo)-5*kilo))
+	c.Assert(bp.FragmentationBytes() < 3*kilo, IsTrue)
+}
+
+func (s *testBytesPoolSuite) TestHealthCheck(c *C) {
+	bp, err := NewBytesPoolWithConfig(kilo, 8*kilo, WithRetain(), WithName("health"))
+	c.Assert(err, IsNil)
+	c.Assert(bp.HealthCheck(-0.1), NotNil)
+	c.Assert(bp.HealthCheck(1.1), NotNil)
+	c.Assert(bp.HealthCheck(0), IsNil)
+
+	// The 1K bucket reuses one bytes, the 4K and 8K buckets miss every time as the bytes are held,
+	// and the 2K bucket is too cold to be checked.
+	var held [][]byte
+	for i := 0; i < 200; i++ {
+		origin, _ := bp.Alloc(kilo)
+		bp.Free(origin)
+		origin, _ = bp.Alloc(4 * kilo)
+		held = append(held, origin)
+		if i < 50 {
+			origin, _ = bp.Alloc(2 * kilo)
+			held = append(held, origin)
+		}
+		if i < 100 {
+			origin, _ = bp.Alloc(8 * kilo)
+			held = append(held, origin)
+		}
+	}
+	c.Assert(bp.HealthCheck(0.5), ErrorMatches, "bytespool: the miss ratios of the buckets of pool health exceed 0.50, 4096: 1.00, 8192: 1.00")
+	c.Assert(bp.HealthCheck(1), IsNil)
+	bp.FreeMany(held)
+	bp.ResetStats()
+	c.Assert(bp.HealthCheck(0), IsNil)
+}
+
+func (s *testBytesPoolSuite) TestResetStats(c *C) {
+	bp, err := NewBytesPoolWithConfig(kilo, 4*kilo, WithBudget(mega), WithSizeHistogram(), WithDoubleFreeCheck())
+	c.Assert(err, IsNil)
+	origin1, _ := bp.Alloc(kilo)
+	origin2, _ := bp.Alloc(kilo)
+	bp.Free(origin1)
+	bp.Alloc(5 * kilo)
+	bp.Free(make([]byte, 100))
+
+	bp.ResetStats()
+	st := bp.Stats()
+	c.Assert(st.Gets, Equals, int64(0))
+	c.Assert(st.Frees, Equals, int64(0))
+	c.Assert(st.Misses, Equals, int64(0))
+	c.Assert(st.Oversized, Equals, int64(0))
+	c.Assert(st.FreeRejections, Equals, int64(0))
+	c.Assert(st.HoldDurations.Count(), Equals, int64(0))
+	for _, n := range bp.RequestSizeHistogram() {
+		c.Assert(n, Equals, int64(0))
+	}
+	// The gauges are kept.
+	c.Assert(st.Buckets[0].Live, Equals, int64(1))
+	c.Assert(bp.LiveBytes(), Equals, int64(kilo))
+	c.Assert(strings.Contains(bp.String(), "1024: live 1,"), IsTrue, Commentf("%s", bp))
+
+	bp.Free(origin2)
+	st = bp.Stats()
+	c.Assert(st.Buckets[0].Live, Equals, int64(0))
+	c.Assert(st.Frees, Equals, int64(1))
+}
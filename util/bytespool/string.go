@@ -0,0 +1,50 @@
+// Copyright 2017 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bytespool
+
+import (
+	"unsafe"
+)
+
+// UnsafeString returns a string which aliases data without copying.
+// It is dangerous: the string is only valid until data is freed or modified,
+// using it after that reads the content of the next owner of the bytes and breaks
+// the immutability of strings. Use BuildString or string(data) unless the string is
+// guaranteed to be discarded before Free.
+func UnsafeString(data []byte) string {
+	if len(data) == 0 {
+		return ""
+	}
+	return *(*string)(unsafe.Pointer(&data))
+}
+
+// BuildString concatenates parts into a scratch buffer allocated from pool and returns a copy of it,
+// the scratch buffer is freed before returning, so the result is safe to keep.
+func BuildString(pool *BytesPool, parts ...string) string {
+	n := 0
+	for _, part := range parts {
+		n += len(part)
+	}
+	if n == 0 {
+		return ""
+	}
+	origin, data := pool.Alloc(n)
+	data = data[:0]
+	for _, part := range parts {
+		data = append(data, part...)
+	}
+	s := string(data)
+	pool.Free(origin)
+	return s
+}
@@ -0,0 +1,39 @@
+// Copyright 2017 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bytespool
+
+import (
+	. "github.com/pingcap/check"
+)
+
+func (s *testBytesPoolSuite) TestUnsafeString(c *C) {
+	c.Assert(UnsafeString(nil), Equals, "")
+	data := []byte("hello")
+	str := UnsafeString(data)
+	c.Assert(str, Equals, "hello")
+	// The string aliases the bytes.
+	data[0] = 'j'
+	c.Assert(str, Equals, "jello")
+}
+
+func (s *testBytesPoolSuite) TestBuildString(c *C) {
+	bp, err := NewBytesPoolWithOptions(WithLeakTracking(), WithPoisonOnFree())
+	c.Assert(err, IsNil)
+	c.Assert(BuildString(bp), Equals, "")
+	c.Assert(BuildString(bp, "", ""), Equals, "")
+	str := BuildString(bp, "hello", ", ", "world")
+	c.Assert(str, Equals, "hello, world")
+	c.Assert(bp.OutstandingAllocations(), HasLen, 0)
+	c.Assert(bp.Stats().Frees, Equals, int64(1))
+}
@@ -0,0 +1,74 @@
+// Copyright 2017 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bytespool
+
+import (
+	"sync"
+)
+
+// tagCounters holds the live bytes of every tag, a tag is dropped once its live bytes fall to zero,
+// so the tags which come and go, like the query IDs, don't grow the map without bound.
+type tagCounters struct {
+	mu   sync.Mutex
+	live map[string]int64
+}
+
+func (t *tagCounters) add(tag string, n int64) {
+	t.mu.Lock()
+	if t.live == nil {
+		t.live = make(map[string]int64)
+	}
+	if v := t.live[tag] + n; v != 0 {
+		t.live[tag] = v
+	} else {
+		delete(t.live, tag)
+	}
+	t.mu.Unlock()
+}
+
+func (t *tagCounters) snapshot() map[string]int64 {
+	t.mu.Lock()
+	m := make(map[string]int64, len(t.live))
+	for tag, n := range t.live {
+		m[tag] = n
+	}
+	t.mu.Unlock()
+	return m
+}
+
+// AllocTagged is like Alloc, but the length of origin is added to the live bytes of tag,
+// so the pooled memory can be attributed to tenants or subsystems. The bytes should be freed by FreeTagged
+// with the same tag. The bytes not from the pool, whose origin is nil, are not accounted.
+// A tag is only kept while it has live bytes, so it can be high-cardinality.
+func (bp *BytesPool) AllocTagged(tag string, size int) (origin, data []byte) {
+	origin, data = bp.alloc(size, 1)
+	if origin != nil {
+		bp.tags.add(tag, int64(len(origin)))
+	}
+	return origin, data
+}
+
+// FreeTagged is like Return, but it subtracts the length of origin from the live bytes of tag,
+// whether the bytes are put back to the pool or not.
+func (bp *BytesPool) FreeTagged(tag string, origin []byte) bool {
+	if origin != nil {
+		bp.tags.add(tag, -int64(len(origin)))
+	}
+	return bp.Return(origin)
+}
+
+// LiveBytesByTag returns the live bytes of every tag allocated by AllocTagged, the tags without live bytes are omitted.
+func (bp *BytesPool) LiveBytesByTag() map[string]int64 {
+	return bp.tags.snapshot()
+}
@@ -0,0 +1,76 @@
+// Copyright 2017 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bytespool
+
+import (
+	"fmt"
+	"sync"
+
+	. "github.com/pingcap/check"
+)
+
+func (s *testBytesPoolSuite) TestAllocTagged(c *C) {
+	bp, err := NewBytesPoolWithConfig(kilo, 4*kilo)
+	c.Assert(err, IsNil)
+	c.Assert(bp.LiveBytesByTag(), HasLen, 0)
+	a, _ := bp.AllocTagged("a", kilo)
+	b, _ := bp.AllocTagged("b", 3*kilo)
+	a2, _ := bp.AllocTagged("a", 100)
+	_, data := bp.AllocTagged("a", 5*kilo)
+	c.Assert(data, HasLen, 5*kilo)
+	c.Assert(bp.LiveBytesByTag(), DeepEquals, map[string]int64{"a": 2 * kilo, "b": 4 * kilo})
+
+	c.Assert(bp.FreeTagged("b", b), IsTrue)
+	c.Assert(bp.FreeTagged("a", a), IsTrue)
+	c.Assert(bp.FreeTagged("a", nil), IsFalse)
+	c.Assert(bp.LiveBytesByTag(), DeepEquals, map[string]int64{"a": kilo})
+	bp.FreeTagged("a", a2)
+	c.Assert(bp.LiveBytesByTag(), HasLen, 0)
+}
+
+func (s *testBytesPoolSuite) TestAllocTaggedConcurrent(c *C) {
+	bp := NewBytesPool()
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(tag string) {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				origin, _ := bp.AllocTagged(tag, kilo)
+				bp.FreeTagged(tag, origin)
+			}
+			bp.AllocTagged(tag, kilo)
+		}(fmt.Sprintf("t%d", i%4))
+	}
+	wg.Wait()
+	live := bp.LiveBytesByTag()
+	c.Assert(live, HasLen, 4)
+	for _, n := range live {
+		c.Assert(n, Equals, int64(2*kilo))
+	}
+}
+
+func (s *testBytesPoolSuite) TestAllocTaggedDropsTags(c *C) {
+	bp := NewBytesPool()
+	for i := 0; i < 1000; i++ {
+		tag := fmt.Sprintf("query-%d", i)
+		origin, _ := bp.AllocTagged(tag, kilo)
+		bp.FreeTagged(tag, origin)
+	}
+	c.Assert(bp.tags.live, HasLen, 0)
+	origin, _ := bp.AllocTagged("a", kilo)
+	c.Assert(bp.tags.live, HasLen, 1)
+	bp.FreeTagged("a", origin)
+	c.Assert(bp.tags.live, HasLen, 0)
+}
@@ -0,0 +1,61 @@
+// Copyright 2017 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bytespool
+
+import (
+	"io"
+)
+
+// TeeReadCloser is like io.TeeReader, it reads from the underlying reader on demand and records
+// the bytes read into a PooledBuffer, so a proxied stream can be kept for logging or retrying.
+// The recording is promoted to a larger bucket when it is full, and the old bucket is freed.
+// It is not thread-safe, and it should be closed to free the recording.
+type TeeReadCloser struct {
+	r      io.Reader
+	buf    *PooledBuffer
+	closed bool
+}
+
+// NewTeeReadCloser creates a TeeReadCloser reading from r, the recording is allocated from pool.
+func NewTeeReadCloser(pool *BytesPool, r io.Reader) *TeeReadCloser {
+	return &TeeReadCloser{
+		r:   r,
+		buf: NewPooledBuffer(pool, 0),
+	}
+}
+
+// Read implements io.Reader interface, the errors of the underlying reader, like io.EOF, are returned as is.
+// It returns ErrClosed after Close without reading from the underlying reader.
+func (t *TeeReadCloser) Read(p []byte) (int, error) {
+	if t.closed {
+		return 0, ErrClosed
+	}
+	n, err := t.r.Read(p)
+	if n > 0 {
+		t.buf.Write(p[:n])
+	}
+	return n, err
+}
+
+// Buffered returns the bytes read so far, the whole stream after Read returns io.EOF.
+// It aliases the pooled storage, so it is only valid until the next Read or Close.
+func (t *TeeReadCloser) Buffered() []byte {
+	return t.buf.Bytes()
+}
+
+// Close frees the recording, it doesn't close the underlying reader.
+func (t *TeeReadCloser) Close() error {
+	t.closed = true
+	return t.buf.Close()
+}
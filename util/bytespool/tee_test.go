@@ -0,0 +1,69 @@
+// Copyright 2017 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bytespool
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	. "github.com/pingcap/check"
+)
+
+var _ io.ReadCloser = &TeeReadCloser{}
+
+func (s *testBytesPoolSuite) TestTeeReadCloser(c *C) {
+	bp, err := NewBytesPoolWithConfig(kilo, 64*kilo, WithLeakTracking())
+	c.Assert(err, IsNil)
+	defer bp.AssertNoLeaks(c)
+	src := strings.Repeat("0123456789", 3*kilo)
+	t := NewTeeReadCloser(bp, strings.NewReader(src))
+	var out bytes.Buffer
+	n, err := io.Copy(&out, t)
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, int64(len(src)))
+	c.Assert(out.String(), Equals, src)
+	c.Assert(string(t.Buffered()), Equals, src)
+	// The recording is promoted to the 32K bucket, and the smaller ones are freed.
+	c.Assert(bp.OutstandingAllocations(), HasLen, 1)
+	c.Assert(bp.OutstandingAllocations()[0].Size, Equals, 32*kilo)
+	c.Assert(t.Close(), IsNil)
+	c.Assert(t.Buffered(), HasLen, 0)
+	_, err = t.Read(make([]byte, 1))
+	c.Assert(err, Equals, ErrClosed)
+	c.Assert(t.Buffered(), HasLen, 0)
+}
+
+func (s *testBytesPoolSuite) TestTeeReadCloserError(c *C) {
+	bp := NewBytesPool()
+	t := NewTeeReadCloser(bp, io.MultiReader(strings.NewReader("abc"), errReader{}))
+	defer t.Close()
+	b, err := ioutil.ReadAll(t)
+	c.Assert(err, ErrorMatches, "read error")
+	c.Assert(string(b), Equals, "abc")
+	c.Assert(string(t.Buffered()), Equals, "abc")
+
+	// The errors of the underlying reader are returned unwrapped.
+	rerr := errors.New("read error")
+	t2 := NewTeeReadCloser(bp, failReader{rerr})
+	defer t2.Close()
+	_, err = t2.Read(make([]byte, 1))
+	c.Assert(err, Equals, rerr)
+	t3 := NewTeeReadCloser(bp, strings.NewReader(""))
+	defer t3.Close()
+	_, err = t3.Read(make([]byte, 1))
+	c.Assert(err, Equals, io.EOF)
+}
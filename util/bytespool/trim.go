@@ -0,0 +1,127 @@
+// Copyright 2017 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bytespool
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// Prefill puts count new bytes into the bucket for size, so the first allocations don't have to create them.
+// In sharded mode the bytes are spread over the shards. The prefilled bytes are not counted as misses.
+// Sizes larger than the max size are prefilled only if the large object pool is enabled.
+func (bp *BytesPool) Prefill(size, count int) {
+	if bp.isClosed() {
+		return
+	}
+	if size > bp.maxSize {
+		if bp.opts.largeGranularity <= 0 {
+			return
+		}
+		n := bp.largeSize(size)
+		p := bp.largePool(n)
+		for j := 0; j < count; j++ {
+			p.Put(bp.newBytes(n))
+		}
+		return
+	}
+	i := bp.allocIdx(size)
+	n := bp.bucketSize(i)
+	if bp.mmaps != nil && i >= bp.mmaps.from {
+		bp.mmaps.prefill(i, n, count)
+		return
+	}
+	shards := bp.loadShards()
+	for j := 0; j < count; j++ {
+		if !bp.admitIdle(i) {
+			return
+		}
+		if bp.retained != nil {
+			bp.retained[i].push(bp.newBucketBytes(n))
+		} else {
+			shards[j%len(shards)][i].Put(bp.newBucketBytes(n))
+		}
+	}
+}
+
+// admitIdle reports whether the i-th bucket can hold another idle bytes and counts it if so.
+func (bp *BytesPool) admitIdle(i int) bool {
+	max := int64(bp.opts.maxIdle)
+	if max <= 0 {
+		return true
+	}
+	idle := &bp.counters[i].idle
+	for {
+		n := atomic.LoadInt64(idle)
+		if n >= max {
+			return false
+		}
+		if atomic.CompareAndSwapInt64(idle, n, n+1) {
+			return true
+		}
+	}
+}
+
+func (bp *BytesPool) resetIdle() {
+	for i := range bp.counters {
+		atomic.StoreInt64(&bp.counters[i].idle, 0)
+	}
+}
+
+// Trim drops the references to the idle bytes held by the pool, so they can be collected by GC.
+// It gives explicit control for memory-sensitive deployments during idle periods,
+// sync.Pool still clears the idle bytes on GC as usual.
+// The bytes cached privately by other Ps can't be reached, so Trim is best effort.
+func (bp *BytesPool) Trim() {
+	for _, buckets := range bp.loadShards() {
+		for i := range buckets {
+			drain(&buckets[i])
+		}
+	}
+	for i := range bp.retained {
+		bp.retained[i].clear()
+	}
+	bp.resetIdle()
+	bp.largePools.Range(func(_, p interface{}) bool {
+		drain(p.(*sync.Pool))
+		return true
+	})
+	if bp.mmaps != nil {
+		bp.mmaps.drain()
+	}
+}
+
+// Clear drops all the idle bytes held by the pool by replacing the buckets with empty ones.
+// It resets retained memory, not in-flight allocations: the bytes allocated before Clear can still be freed,
+// and it is safe to be called concurrently with Alloc and Free. It is mainly used for test isolation,
+// or to release the memory after a batch job.
+func (bp *BytesPool) Clear() {
+	bp.storeShards(bp.newShards())
+	for i := range bp.retained {
+		bp.retained[i].clear()
+	}
+	bp.resetIdle()
+	bp.largePools.Range(func(size, _ interface{}) bool {
+		bp.largePools.Delete(size)
+		return true
+	})
+	if bp.mmaps != nil {
+		bp.mmaps.drain()
+	}
+}
+
+func drain(p *sync.Pool) {
+	for p.Get() != nil {
+	}
+}
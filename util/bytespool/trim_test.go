@@ -0,0 +1,116 @@
+// Copyright 2017 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bytespool
+
+import (
+	"sync"
+
+	. "github.com/pingcap/check"
+)
+
+func (s *testBytesPoolSuite) TestPrefillAndTrim(c *C) {
+	bp, err := NewBytesPoolWithConfig(kilo, 16*kilo, WithLargeObjectPool(16*kilo))
+	c.Assert(err, IsNil)
+	bp.Prefill(10*kilo, 10)
+	bp.Prefill(20*kilo, 2)
+	for i := 0; i < 10; i++ {
+		origin, _ := bp.Alloc(16 * kilo)
+		c.Assert(len(origin), Equals, 16*kilo)
+	}
+	// sync.Pool may drop the bytes randomly with the race detector,
+	// so only check that misses never exceed the allocations.
+	st := bp.Stats()
+	c.Assert(st.Buckets[4].Gets, Equals, int64(10))
+	c.Assert(st.Buckets[4].Misses <= 10, IsTrue)
+
+	origin, _ := bp.Alloc(kilo)
+	bp.Free(origin)
+	bp.Trim()
+	bp.Alloc(kilo)
+	st = bp.Stats()
+	c.Assert(st.Buckets[0].Misses, Equals, int64(2))
+
+	// Sharded pools are prefilled and trimmed on all the shards.
+	bp, err = NewShardedBytesPool(4)
+	c.Assert(err, IsNil)
+	bp.Prefill(kilo, 8)
+	bp.Prefill(256*mega, 1)
+	bp.Trim()
+	for _, buckets := range bp.loadShards() {
+		c.Assert(buckets[0].Get(), IsNil)
+	}
+}
+
+func (s *testBytesPoolSuite) TestClear(c *C) {
+	bp, err := NewBytesPoolWithConfig(kilo, 4*kilo, WithLargeObjectPool(4*kilo))
+	c.Assert(err, IsNil)
+	bp.Prefill(kilo, 10)
+	bp.Prefill(8*kilo, 10)
+	inUse, _ := bp.Alloc(2 * kilo)
+	bp.Clear()
+	shards := bp.loadShards()
+	c.Assert(shards[0][0].Get(), IsNil)
+	c.Assert(bp.largePool(8*kilo).Get(), IsNil)
+	// The bytes in use can be freed after Clear.
+	c.Assert(bp.Free(inUse), Equals, 1)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				origin, _ := bp.Alloc(j * 100)
+				bp.Free(origin)
+				if j%10 == 0 {
+					bp.Clear()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func (s *testBytesPoolSuite) TestMaxIdlePerBucket(c *C) {
+	_, err := NewBytesPoolWithOptions(WithMaxIdlePerBucket(-1))
+	c.Assert(err, NotNil)
+
+	bp, err := NewBytesPoolWithOptions(WithMaxIdlePerBucket(2))
+	c.Assert(err, IsNil)
+	origins := make([][]byte, 3)
+	for i := range origins {
+		origins[i], _ = bp.Alloc(kilo)
+	}
+	c.Assert(bp.Free(origins[0]), Equals, 0)
+	c.Assert(bp.Free(origins[1]), Equals, 0)
+	c.Assert(bp.Free(origins[2]), Equals, -1)
+	st := bp.Stats()
+	c.Assert(st.Frees, Equals, int64(2))
+	c.Assert(st.FreeRejections, Equals, int64(1))
+
+	// Other buckets are not affected.
+	origin, _ := bp.Alloc(2 * kilo)
+	c.Assert(bp.Free(origin), Equals, 1)
+
+	// Trim resets the idle count.
+	bp.Trim()
+	c.Assert(bp.Free(origins[0]), Equals, 0)
+	c.Assert(bp.Free(origins[1]), Equals, 0)
+	c.Assert(bp.Free(origins[2]), Equals, -1)
+
+	// Prefill is limited as well.
+	bp.Clear()
+	bp.Prefill(kilo, 5)
+	c.Assert(bp.counters[0].idle, Equals, int64(2))
+}
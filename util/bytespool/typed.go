@@ -0,0 +1,140 @@
+// Copyright 2017 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bytespool
+
+import (
+	"reflect"
+	"sync/atomic"
+	"unsafe"
+)
+
+// The typed pools reinterpret the pooled bytes as slices of fixed-size numeric types,
+// all the unsafe conversions are kept in this file. The element types must not contain pointers,
+// and the bytes are in the native byte order.
+
+// castBytes makes sh point to the backing array of b, with the length and capacity counted in elements.
+func castBytes(b []byte, elemSize int, sh *reflect.SliceHeader) {
+	if cap(b) == 0 {
+		return
+	}
+	sh.Data = uintptr(unsafe.Pointer(&b[:1][0]))
+	sh.Len = len(b) / elemSize
+	sh.Cap = cap(b) / elemSize
+}
+
+// uncastBytes returns the bytes of the slice described by sh, it reverses castBytes.
+func uncastBytes(sh *reflect.SliceHeader, elemSize int) (b []byte) {
+	if sh.Cap == 0 {
+		return nil
+	}
+	pbytes := (*reflect.SliceHeader)(unsafe.Pointer(&b))
+	pbytes.Data = sh.Data
+	pbytes.Len = sh.Len * elemSize
+	pbytes.Cap = sh.Cap * elemSize
+	return
+}
+
+// elemsOverflow reports whether the bytes of n elements of elemSize bytes overflow int, a negative n panics.
+// The allocations which overflow are counted as oversized, and the caller makes them on the heap with a nil origin
+// like the oversized path of BytesPool.Alloc. The alloc hook gets the max int as their size.
+func (bp *BytesPool) elemsOverflow(n, elemSize int) bool {
+	checkSize(n)
+	if n <= maxInt/elemSize {
+		return false
+	}
+	bp.checkOpen()
+	atomic.AddInt64(&bp.oversized, 1)
+	if bp.opts.allocHook != nil {
+		bp.opts.allocHook(maxInt, -1)
+	}
+	return true
+}
+
+// Int32Pool allocates []int32 from a BytesPool.
+type Int32Pool struct {
+	pool *BytesPool
+}
+
+// NewInt32Pool creates an Int32Pool which allocates from pool.
+func NewInt32Pool(pool *BytesPool) *Int32Pool {
+	return &Int32Pool{pool: pool}
+}
+
+// Alloc allocates n int32s, the returned origin should be freed by Free like BytesPool.Alloc.
+func (p *Int32Pool) Alloc(n int) (origin, data []int32) {
+	if p.pool.elemsOverflow(n, 4) {
+		return nil, make([]int32, n)
+	}
+	o, d := p.pool.Alloc(n * 4)
+	castBytes(o, 4, (*reflect.SliceHeader)(unsafe.Pointer(&origin)))
+	castBytes(d, 4, (*reflect.SliceHeader)(unsafe.Pointer(&data)))
+	return
+}
+
+// Free frees the origin returned by Alloc, it returns the bucket index like BytesPool.Free.
+func (p *Int32Pool) Free(origin []int32) int {
+	return p.pool.Free(uncastBytes((*reflect.SliceHeader)(unsafe.Pointer(&origin)), 4))
+}
+
+// Int64Pool allocates []int64 from a BytesPool.
+type Int64Pool struct {
+	pool *BytesPool
+}
+
+// NewInt64Pool creates an Int64Pool which allocates from pool.
+func NewInt64Pool(pool *BytesPool) *Int64Pool {
+	return &Int64Pool{pool: pool}
+}
+
+// Alloc allocates n int64s, the returned origin should be freed by Free like BytesPool.Alloc.
+func (p *Int64Pool) Alloc(n int) (origin, data []int64) {
+	if p.pool.elemsOverflow(n, 8) {
+		return nil, make([]int64, n)
+	}
+	o, d := p.pool.Alloc(n * 8)
+	castBytes(o, 8, (*reflect.SliceHeader)(unsafe.Pointer(&origin)))
+	castBytes(d, 8, (*reflect.SliceHeader)(unsafe.Pointer(&data)))
+	return
+}
+
+// Free frees the origin returned by Alloc, it returns the bucket index like BytesPool.Free.
+func (p *Int64Pool) Free(origin []int64) int {
+	return p.pool.Free(uncastBytes((*reflect.SliceHeader)(unsafe.Pointer(&origin)), 8))
+}
+
+// Float64Pool allocates []float64 from a BytesPool.
+type Float64Pool struct {
+	pool *BytesPool
+}
+
+// NewFloat64Pool creates a Float64Pool which allocates from pool.
+func NewFloat64Pool(pool *BytesPool) *Float64Pool {
+	return &Float64Pool{pool: pool}
+}
+
+// Alloc allocates n float64s, the returned origin should be freed by Free like BytesPool.Alloc.
+func (p *Float64Pool) Alloc(n int) (origin, data []float64) {
+	if p.pool.elemsOverflow(n, 8) {
+		return nil, make([]float64, n)
+	}
+	o, d := p.pool.Alloc(n * 8)
+	castBytes(o, 8, (*reflect.SliceHeader)(unsafe.Pointer(&origin)))
+	castBytes(d, 8, (*reflect.SliceHeader)(unsafe.Pointer(&data)))
+	return
+}
+
+// Free frees the origin returned by Alloc, it returns the bucket index like BytesPool.Free.
+func (p *Float64Pool) Free(origin []float64) int {
+	return p.pool.Free(uncastBytes((*reflect.SliceHeader)(unsafe.Pointer(&origin)), 8))
+}
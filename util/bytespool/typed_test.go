@@ -0,0 +1,80 @@
+// Copyright 2017 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bytespool
+
+import (
+	. "github.com/pingcap/check"
+)
+
+func (s *testBytesPoolSuite) TestTypedPools(c *C) {
+	bp, err := NewBytesPoolWithConfig(kilo, 16*kilo, WithDoubleFreeCheck())
+	c.Assert(err, IsNil)
+
+	int32s := NewInt32Pool(bp)
+	origin, data := int32s.Alloc(300)
+	c.Assert(len(data), Equals, 300)
+	c.Assert(len(origin), Equals, 2*kilo/4)
+	for i := range data {
+		data[i] = int32(i)
+	}
+	c.Assert(data[299], Equals, int32(299))
+	c.Assert(int32s.Free(origin), Equals, 1)
+
+	int64s := NewInt64Pool(bp)
+	origin64, data64 := int64s.Alloc(128)
+	c.Assert(len(data64), Equals, 128)
+	c.Assert(len(origin64), Equals, 128)
+	c.Assert(int64s.Free(origin64), Equals, 0)
+	origin64, data64 = int64s.Alloc(4 * kilo)
+	c.Assert(origin64, IsNil)
+	c.Assert(len(data64), Equals, 4*kilo)
+	c.Assert(int64s.Free(origin64), Equals, -1)
+
+	float64s := NewFloat64Pool(bp)
+	originF, dataF := float64s.Alloc(1000)
+	c.Assert(len(dataF), Equals, 1000)
+	c.Assert(len(originF), Equals, 8*kilo/8)
+	dataF[999] = 1.5
+	c.Assert(originF[999], Equals, 1.5)
+	c.Assert(float64s.Free(originF), Equals, 3)
+
+	originF, dataF = float64s.Alloc(0)
+	c.Assert(len(dataF), Equals, 0)
+	c.Assert(float64s.Free(originF), Equals, -1)
+}
+
+func (s *testBytesPoolSuite) TestTypedPoolsOverflow(c *C) {
+	var hooked []int
+	bp, err := NewBytesPoolWithOptions(WithAllocHook(func(size, i int) {
+		hooked = append(hooked, size)
+	}))
+	c.Assert(err, IsNil)
+	c.Assert(func() { NewInt32Pool(bp).Alloc(-1) }, PanicMatches, "bytespool: negative size")
+	// The bytes of the huge counts overflow int, they would wrap to a short or negative size.
+	c.Assert(bp.elemsOverflow(maxInt/4, 4), IsFalse)
+	c.Assert(bp.elemsOverflow(maxInt/4+1, 4), IsTrue)
+	c.Assert(bp.elemsOverflow(maxInt/8+1, 8), IsTrue)
+	c.Assert(bp.Stats().Oversized, Equals, int64(2))
+	c.Assert(hooked, DeepEquals, []int{maxInt, maxInt})
+	// The overflowed counts reach make instead of allocating the wrapped size from the pool.
+	for _, alloc := range []func(){
+		func() { NewInt32Pool(bp).Alloc(maxInt/2 + 1) },
+		func() { NewInt64Pool(bp).Alloc(maxInt/4 + 1) },
+		func() { NewFloat64Pool(bp).Alloc(maxInt/4 + 1) },
+	} {
+		c.Assert(alloc, PanicMatches, ".*makeslice: len out of range")
+	}
+	c.Assert(bp.Stats().Gets, Equals, int64(0))
+	c.Assert(bp.Stats().Oversized, Equals, int64(5))
+}
@@ -0,0 +1,117 @@
+// Copyright 2017 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bytespool
+
+import (
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// adaptiveWarmInterval is how often the warmer of WithAdaptiveWarm runs, it is a variable to be shortened in tests.
+var adaptiveWarmInterval = time.Second
+
+// AdaptiveWarmStats is the statistics of the background warmer of WithAdaptiveWarm.
+// The allocations it saves are counted as the hits in Stats, and the cold ones as the misses.
+type AdaptiveWarmStats struct {
+	// Runs is the number of warmings done.
+	Runs int64
+	// Warmed is the number of bytes created by the warmer ahead of the allocations.
+	Warmed int64
+	// WarmedBytes is the total size of the warmed bytes.
+	WarmedBytes int64
+}
+
+// adaptiveWarmer keeps the idle bytes of every bucket proportional to its allocation rate.
+type adaptiveWarmer struct {
+	stopCh chan struct{}
+	doneCh chan struct{}
+	// lastGets is the gets of every bucket at the last run, it is only accessed by the goroutine.
+	lastGets []int64
+
+	mu    sync.Mutex
+	stats AdaptiveWarmStats
+}
+
+func newAdaptiveWarmer(numBuckets int) *adaptiveWarmer {
+	return &adaptiveWarmer{
+		stopCh:   make(chan struct{}),
+		doneCh:   make(chan struct{}),
+		lastGets: make([]int64, numBuckets),
+	}
+}
+
+func (w *adaptiveWarmer) run(bp *BytesPool, interval time.Duration, ratio float64) {
+	defer close(w.doneCh)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			w.warm(bp, ratio)
+		case <-w.stopCh:
+			return
+		}
+	}
+}
+
+// warm prefills every bucket up to ratio times the allocations since the last run.
+func (w *adaptiveWarmer) warm(bp *BytesPool, ratio float64) {
+	var warmed, warmedBytes int64
+	for i := range w.lastGets {
+		gets := atomic.LoadInt64(&bp.counters[i].gets)
+		delta := gets - w.lastGets[i]
+		if delta < 0 {
+			// The counters are reset by ResetStats.
+			delta = gets
+		}
+		w.lastGets[i] = gets
+		if bp.mmaps != nil && i >= bp.mmaps.from {
+			continue
+		}
+		target := int(math.Ceil(float64(delta) * ratio))
+		idle := bp.retained[i].len()
+		if idle >= target {
+			continue
+		}
+		bp.Prefill(bp.bucketSize(i), target-idle)
+		if n := bp.retained[i].len() - idle; n > 0 {
+			warmed += int64(n)
+			warmedBytes += int64(n) * int64(bp.bucketSize(i))
+		}
+	}
+	w.mu.Lock()
+	w.stats.Runs++
+	w.stats.Warmed += warmed
+	w.stats.WarmedBytes += warmedBytes
+	w.mu.Unlock()
+}
+
+// stop stops the goroutine and waits for it to exit.
+func (w *adaptiveWarmer) stop() {
+	close(w.stopCh)
+	<-w.doneCh
+}
+
+// AdaptiveWarmStats returns the statistics of the background warmer, it is zero if WithAdaptiveWarm is not used.
+func (bp *BytesPool) AdaptiveWarmStats() AdaptiveWarmStats {
+	if bp.warmer == nil {
+		return AdaptiveWarmStats{}
+	}
+	bp.warmer.mu.Lock()
+	st := bp.warmer.stats
+	bp.warmer.mu.Unlock()
+	return st
+}
@@ -0,0 +1,80 @@
+// Copyright 2017 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bytespool
+
+import (
+	"time"
+
+	. "github.com/pingcap/check"
+)
+
+func (s *testBytesPoolSuite) TestAdaptiveWarm(c *C) {
+	_, err := NewBytesPoolWithOptions(WithAdaptiveWarm(1))
+	c.Assert(err, NotNil)
+	_, err = NewBytesPoolWithOptions(WithRetain(), WithAdaptiveWarm(-1))
+	c.Assert(err, NotNil)
+	c.Assert(NewBytesPool().AdaptiveWarmStats(), Equals, AdaptiveWarmStats{})
+
+	bp, err := NewBytesPoolWithOptions(WithRetain())
+	c.Assert(err, IsNil)
+	w := newAdaptiveWarmer(bp.numBuckets)
+	origins := make([][]byte, 0, 10)
+	for i := 0; i < 8; i++ {
+		origin, _ := bp.Alloc(4 * kilo)
+		origins = append(origins, origin)
+	}
+	origin, _ := bp.Alloc(kilo)
+	origins = append(origins, origin)
+	w.warm(bp, 0.5)
+	c.Assert(bp.retained[2].len(), Equals, 4)
+	c.Assert(bp.retained[0].len(), Equals, 1)
+	c.Assert(w.stats, Equals, AdaptiveWarmStats{Runs: 1, Warmed: 5, WarmedBytes: 17 * kilo})
+
+	// The warmed bytes serve the next allocations as hits.
+	st := bp.Stats()
+	for i := 0; i < 4; i++ {
+		origin, _ := bp.Alloc(4 * kilo)
+		origins = append(origins, origin)
+	}
+	c.Assert(bp.Stats().Misses, Equals, st.Misses)
+	c.Assert(bp.retained[2].len(), Equals, 0)
+	w.warm(bp, 0.5)
+	c.Assert(bp.retained[2].len(), Equals, 2)
+	// Nothing is allocated since the last run, so nothing is warmed.
+	w.warm(bp, 0.5)
+	c.Assert(w.stats.Warmed, Equals, int64(7))
+	for _, origin := range origins {
+		bp.Free(origin)
+	}
+}
+
+func (s *testBytesPoolSuite) TestAdaptiveWarmBackground(c *C) {
+	defer func(d time.Duration) { adaptiveWarmInterval = d }(adaptiveWarmInterval)
+	adaptiveWarmInterval = time.Millisecond
+	bp, err := NewBytesPoolWithOptions(WithRetain(), WithAdaptiveWarm(1))
+	c.Assert(err, IsNil)
+	// The bytes are held, so the bucket is empty when the warmer sees the allocations.
+	origins, _ := bp.AllocMany([]int{4 * kilo, 4 * kilo, 4 * kilo, 4 * kilo})
+	for i := 0; i < 1000 && bp.AdaptiveWarmStats().Warmed == 0; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	c.Assert(bp.AdaptiveWarmStats().Warmed > 0, IsTrue)
+	bp.FreeMany(origins)
+
+	// Close stops the warmer.
+	c.Assert(bp.Close(), IsNil)
+	runs := bp.AdaptiveWarmStats().Runs
+	time.Sleep(5 * time.Millisecond)
+	c.Assert(bp.AdaptiveWarmStats().Runs, Equals, runs)
+}
@@ -0,0 +1,144 @@
+// Copyright 2017 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bytespool
+
+import (
+	"io"
+
+	"github.com/juju/errors"
+)
+
+// BufferedWriter is a buffered io.Writer like bufio.Writer, but its buffer is allocated from a BytesPool,
+// so many connection writers can share the pooled storage. It is not thread-safe.
+// After an error, all the following writes and flushes return the error.
+type BufferedWriter struct {
+	pool   *BytesPool
+	w      io.Writer
+	origin []byte
+	buf    []byte
+	n      int
+	err    error
+}
+
+// NewBufioWriterSize creates a BufferedWriter writing to w with a pooled buffer of size bytes,
+// release flushes nothing but frees the buffer, it is the same as the Release method.
+func NewBufioWriterSize(pool *BytesPool, w io.Writer, size int) (bw *BufferedWriter, release func()) {
+	if size <= 0 {
+		size = defaultBufSize
+	}
+	origin, data := pool.Alloc(size)
+	bw = &BufferedWriter{
+		pool:   pool,
+		w:      w,
+		origin: origin,
+		buf:    data,
+	}
+	return bw, bw.Release
+}
+
+// defaultBufSize is the buffer size used when NewBufioWriterSize is called with a non-positive size.
+const defaultBufSize = 4096
+
+// Write implements io.Writer interface. When p is larger than the buffer and the buffer is empty,
+// p is written to the underlying writer directly.
+func (b *BufferedWriter) Write(p []byte) (int, error) {
+	if b.buf == nil {
+		return 0, errors.New("bytespool: write to a released writer")
+	}
+	var nn int
+	for len(p) > len(b.buf)-b.n && b.err == nil {
+		var n int
+		if b.n == 0 {
+			n, b.err = b.w.Write(p)
+		} else {
+			n = copy(b.buf[b.n:], p)
+			b.n += n
+			b.Flush()
+		}
+		nn += n
+		p = p[n:]
+	}
+	if b.err != nil {
+		return nn, b.err
+	}
+	n := copy(b.buf[b.n:], p)
+	b.n += n
+	return nn + n, nil
+}
+
+// WriteString is like Write, but it copies s into the buffer directly without converting it to []byte,
+// a long s is written through the buffer chunk by chunk, so writing strings never allocates.
+func (b *BufferedWriter) WriteString(s string) (int, error) {
+	if b.buf == nil {
+		return 0, errors.New("bytespool: write to a released writer")
+	}
+	var nn int
+	for len(s) > len(b.buf)-b.n && b.err == nil {
+		n := copy(b.buf[b.n:], s)
+		b.n += n
+		nn += n
+		s = s[n:]
+		b.Flush()
+	}
+	if b.err != nil {
+		return nn, b.err
+	}
+	n := copy(b.buf[b.n:], s)
+	b.n += n
+	return nn + n, nil
+}
+
+// Flush writes the buffered data to the underlying writer.
+// The error of the writer is returned as is, and io.ErrShortWrite if it writes less without an error, like bufio.Writer.
+func (b *BufferedWriter) Flush() error {
+	if b.err != nil {
+		return b.err
+	}
+	if b.n == 0 {
+		return nil
+	}
+	n, err := b.w.Write(b.buf[:b.n])
+	if n < b.n && err == nil {
+		err = io.ErrShortWrite
+	}
+	if err != nil {
+		if n > 0 && n < b.n {
+			copy(b.buf, b.buf[n:b.n])
+		}
+		b.n -= n
+		b.err = err
+		return b.err
+	}
+	b.n = 0
+	return nil
+}
+
+// Buffered returns the number of bytes that have been written into the buffer but not flushed.
+func (b *BufferedWriter) Buffered() int {
+	return b.n
+}
+
+// Available returns the number of bytes unused in the buffer.
+func (b *BufferedWriter) Available() int {
+	return len(b.buf) - b.n
+}
+
+// Release frees the buffer to the pool without flushing it, the buffered data is dropped.
+// It is safe to call Release more than once.
+func (b *BufferedWriter) Release() {
+	if b.origin != nil {
+		b.pool.Free(b.origin)
+	}
+	b.origin, b.buf, b.n = nil, nil, 0
+}
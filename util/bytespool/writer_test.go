@@ -0,0 +1,147 @@
+// Copyright 2017 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bytespool
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	. "github.com/pingcap/check"
+)
+
+var _ io.Writer = &BufferedWriter{}
+
+type countWriter struct {
+	bytes.Buffer
+	writes int
+}
+
+func (w *countWriter) Write(p []byte) (int, error) {
+	w.writes++
+	return w.Buffer.Write(p)
+}
+
+func (s *testBytesPoolSuite) TestBufferedWriter(c *C) {
+	bp, err := NewBytesPoolWithConfig(kilo, 4*kilo, WithLeakTracking())
+	c.Assert(err, IsNil)
+	var w countWriter
+	bw, release := NewBufioWriterSize(bp, &w, kilo)
+	c.Assert(bw.Available(), Equals, kilo)
+
+	var expect bytes.Buffer
+	for i := 0; i < 1000; i++ {
+		fmt.Fprintf(bw, "%d,", i)
+		fmt.Fprintf(&expect, "%d,", i)
+	}
+	c.Assert(bw.Buffered()+w.Len(), Equals, expect.Len())
+	c.Assert(w.writes, Equals, w.Len()/kilo)
+	c.Assert(bw.Flush(), IsNil)
+	c.Assert(bw.Buffered(), Equals, 0)
+	c.Assert(w.String(), Equals, expect.String())
+
+	// A large write bypasses the empty buffer.
+	w.Reset()
+	w.writes = 0
+	large := bytes.Repeat([]byte{'x'}, 3*kilo)
+	n, err := bw.Write(large)
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, len(large))
+	c.Assert(w.writes, Equals, 1)
+	c.Assert(bw.Buffered(), Equals, 0)
+
+	release()
+	bw.Release()
+	c.Assert(bp.OutstandingAllocations(), HasLen, 0)
+	_, err = bw.Write([]byte("a"))
+	c.Assert(err, NotNil)
+}
+
+func (s *testBytesPoolSuite) TestBufferedWriterError(c *C) {
+	bp, err := NewBytesPoolWithConfig(kilo, 4*kilo)
+	c.Assert(err, IsNil)
+	bw, release := NewBufioWriterSize(bp, errWriter{}, kilo)
+	defer release()
+
+	_, err = bw.Write([]byte("abc"))
+	c.Assert(err, IsNil)
+	c.Assert(bw.Flush(), ErrorMatches, "write error")
+	c.Assert(bw.Buffered(), Equals, 3)
+	_, err = bw.Write([]byte("d"))
+	c.Assert(err, ErrorMatches, "write error")
+
+	// The errors of the writer are returned unwrapped.
+	werr := errors.New("write error")
+	bw2, release2 := NewBufioWriterSize(bp, failWriter{werr}, kilo)
+	defer release2()
+	bw2.WriteString("abc")
+	c.Assert(bw2.Flush(), Equals, werr)
+	bw3, release3 := NewBufioWriterSize(bp, shortWriter{1}, kilo)
+	defer release3()
+	bw3.WriteString("abc")
+	c.Assert(bw3.Flush(), Equals, io.ErrShortWrite)
+	c.Assert(bw3.Buffered(), Equals, 2)
+}
+
+func (s *testBytesPoolSuite) TestBufferedWriterWriteString(c *C) {
+	bp, err := NewBytesPoolWithConfig(kilo, 4*kilo)
+	c.Assert(err, IsNil)
+	var w countWriter
+	bw, release := NewBufioWriterSize(bp, &w, kilo)
+	defer release()
+
+	var expect bytes.Buffer
+	for i := 0; i < 100; i++ {
+		s := fmt.Sprintf("fragment %d,", i)
+		n, err := bw.WriteString(s)
+		c.Assert(err, IsNil)
+		c.Assert(n, Equals, len(s))
+		expect.WriteString(s)
+	}
+	long := strings.Repeat("x", 3*kilo+10)
+	n, err := bw.WriteString(long)
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, len(long))
+	expect.WriteString(long)
+	c.Assert(bw.Flush(), IsNil)
+	c.Assert(w.String(), Equals, expect.String())
+
+	fragment := strings.Repeat("y", 100)
+	allocs := testing.AllocsPerRun(100, func() {
+		bw.WriteString(fragment)
+	})
+	c.Assert(allocs, Equals, float64(0))
+
+	buf := NewPooledBuffer(bp, 4*kilo)
+	defer buf.Close()
+	allocs = testing.AllocsPerRun(10, func() {
+		buf.Reset()
+		buf.WriteString(fragment)
+	})
+	c.Assert(allocs, Equals, float64(0))
+}
+
+func (s *testBytesPoolSuite) TestBufferedWriterWriteStringError(c *C) {
+	bp := NewBytesPool()
+	bw, release := NewBufioWriterSize(bp, errWriter{}, kilo)
+	defer release()
+	n, err := bw.WriteString(strings.Repeat("x", kilo+1))
+	c.Assert(err, ErrorMatches, "write error")
+	c.Assert(n, Equals, kilo)
+	_, err = bw.WriteString("a")
+	c.Assert(err, ErrorMatches, "write error")
+}